@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SessionAccess int
+
+const (
+	SessionAccessNone SessionAccess = iota
+	SessionAccessView
+	SessionAccessFull
+	SessionAccessOwner
+)
+
+const (
+	sessionAccessCookiePrefix = "demo_access_"
+	sessionShareParameter     = "share"
+	defaultShareLifetime      = time.Hour
+	adminOpenLinkLifetime     = 5 * time.Minute
+)
+
+func (access SessionAccess) String() string {
+	switch access {
+	case SessionAccessView:
+		return "view"
+	case SessionAccessFull:
+		return "full"
+	case SessionAccessOwner:
+		return "owner"
+	default:
+		return ""
+	}
+}
+
+func parseSessionAccess(name string) (SessionAccess, bool) {
+	switch name {
+	case "view":
+		return SessionAccessView, true
+	case "full":
+		return SessionAccessFull, true
+	case "owner":
+		return SessionAccessOwner, true
+	default:
+		return SessionAccessNone, false
+	}
+}
+
+func loadSessionKey(configuredKey string) ([]byte, bool, error) {
+	if configuredKey != "" {
+		return []byte(configuredKey), true, nil
+	}
+
+	randomKey := make([]byte, 32)
+	if _, err := rand.Read(randomKey); err != nil {
+		return nil, false, err
+	}
+
+	return randomKey, false, nil
+}
+
+// Tokens are <payload>.<signature> where the payload is access:expiresUnix:sessionId, base64url encoded
+func (server *Server) signSessionToken(sessionId string, access SessionAccess, expiresUtc time.Time) string {
+	payload := access.String() + ":" + strconv.FormatInt(expiresUtc.Unix(), 10) + ":" + sessionId
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(server.sessionTokenSignature(payload))
+}
+
+func (server *Server) sessionTokenSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, server.SessionKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (server *Server) verifySessionToken(token string, sessionId string) (SessionAccess, time.Time, bool) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, server.sessionTokenSignature(string(payloadBytes))) {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	fields := strings.SplitN(string(payloadBytes), ":", 3)
+	if len(fields) != 3 || fields[2] != sessionId {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	access, ok := parseSessionAccess(fields[0])
+	if !ok {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	expiresUnix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	expiresUtc := time.Unix(expiresUnix, 0).UTC()
+	if !time.Now().Before(expiresUtc) {
+		return SessionAccessNone, time.Time{}, false
+	}
+
+	return access, expiresUtc, true
+}
+
+func (server *Server) requestSessionAccess(request *http.Request, sessionId string) SessionAccess {
+	cookie, err := request.Cookie(sessionAccessCookiePrefix + sessionId)
+	if err != nil {
+		return SessionAccessNone
+	}
+
+	access, _, ok := server.verifySessionToken(cookie.Value, sessionId)
+	if !ok {
+		return SessionAccessNone
+	}
+
+	return access
+}
+
+func (server *Server) isSessionAccessDenied(request *http.Request, sessionId string) bool {
+	server.SessionsMutex.RLock()
+	session, ok := server.Sessions[sessionId]
+	ok = ok && !session.Pooled
+	server.SessionsMutex.RUnlock()
+
+	return ok && server.requestSessionAccess(request, sessionId) == SessionAccessNone
+}
+
+func (server *Server) setSessionAccessCookie(writer http.ResponseWriter, request *http.Request, sessionId string, access SessionAccess, expiresUtc time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionAccessCookiePrefix + sessionId,
+		Value:    server.signSessionToken(sessionId, access, expiresUtc),
+		Path:     "/",
+		Expires:  expiresUtc,
+		HttpOnly: true,
+		Secure:   request.TLS != nil || (server.TrustForwardedFor && request.Header.Get("X-Forwarded-Proto") == "https"),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// Session subdomains need the cookie too
+	if server.DemoDomain != "" {
+		cookie.Domain = server.DemoDomain
+	}
+
+	http.SetCookie(writer, cookie)
+}
+
+// Access tokens never leave the controller, the session containers run shells other visitors may control
+func stripSessionAccessCookies(header http.Header) {
+	cookieHeaders := header.Values("Cookie")
+	if len(cookieHeaders) == 0 {
+		return
+	}
+
+	keptCookies := []string{}
+	for _, cookieHeader := range cookieHeaders {
+		for cookie := range strings.SplitSeq(cookieHeader, ";") {
+			cookie = strings.TrimSpace(cookie)
+			name, _, _ := strings.Cut(cookie, "=")
+			if cookie == "" || strings.HasPrefix(strings.TrimSpace(name), sessionAccessCookiePrefix) {
+				continue
+			}
+
+			keptCookies = append(keptCookies, cookie)
+		}
+	}
+
+	header.Del("Cookie")
+	if len(keptCookies) > 0 {
+		header.Set("Cookie", strings.Join(keptCookies, "; "))
+	}
+}
+
+func (server *Server) ownerCookieExpiresUtc(session *Session) time.Time {
+	return server.maxExpiresUtc(session).Add(server.FailedSessionGrace)
+}
+
+func (server *Server) sessionShareUrl(request *http.Request, sessionId string, token string) string {
+	path := "/session/" + sessionId + "/?" + sessionShareParameter + "=" + url.QueryEscape(token)
+	if server.DemoDomain != "" {
+		return server.demoDomainUrl(request, path)
+	}
+
+	return path
+}
+
+// Share links land here, the token is swapped for a cookie so it does not linger in the address bar
+func (server *Server) redeemShareToken(writer http.ResponseWriter, request *http.Request, sessionId string, token string) {
+	access, expiresUtc, ok := server.verifySessionToken(token, sessionId)
+	if !ok {
+		server.writeSessionForbiddenHtml(writer, "This share link is invalid or has expired")
+		return
+	}
+
+	if server.requestSessionAccess(request, sessionId) < access {
+		server.setSessionAccessCookie(writer, request, sessionId, access, expiresUtc)
+		server.sessionIdLogger(sessionId).Info("Share link redeemed", "access", access.String(), "expires_utc", expiresUtc)
+	}
+
+	redirectUrl := *request.URL
+	query := redirectUrl.Query()
+	query.Del(sessionShareParameter)
+	redirectUrl.RawQuery = query.Encode()
+	http.Redirect(writer, request, redirectUrl.RequestURI(), http.StatusSeeOther)
+}
+
+type sessionShareResponse struct {
+	Url        string    `json:"url"`
+	Access     string    `json:"access"`
+	ExpiresUtc time.Time `json:"expiresUtc"`
+}
+
+func (server *Server) handleShare(writer http.ResponseWriter, request *http.Request, session *Session) {
+	access, ok := parseSessionAccess(request.URL.Query().Get("access"))
+	if !ok || access == SessionAccessOwner {
+		http.Error(writer, "Invalid access, expected view or full", http.StatusBadRequest)
+		return
+	}
+
+	lifetime := defaultShareLifetime
+	if secondsText := request.URL.Query().Get("seconds"); secondsText != "" {
+		seconds, err := strconv.Atoi(secondsText)
+		if err != nil || seconds <= 0 {
+			http.Error(writer, "Invalid seconds", http.StatusBadRequest)
+			return
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	expiresUtc := time.Now().UTC().Add(lifetime).Truncate(time.Second)
+	if maxExpiresUtc := server.maxExpiresUtc(session); expiresUtc.After(maxExpiresUtc) {
+		expiresUtc = maxExpiresUtc
+	}
+
+	server.sessionIdLogger(session.Id).Info("Share link created", "access", access.String(), "expires_utc", expiresUtc)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(writer).Encode(sessionShareResponse{
+		Url:        server.sessionShareUrl(request, session.Id, server.signSessionToken(session.Id, access, expiresUtc)),
+		Access:     access.String(),
+		ExpiresUtc: expiresUtc,
+	})
+}
+
+func (server *Server) writeSessionForbiddenHtml(writer http.ResponseWriter, subtitle string) {
+	actionsHtml := `<a class="button" href="/">Start your own session</a>`
+	server.writeLiveHtml(writer, http.StatusForbidden, "Session not available", subtitle, "", actionsHtml)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStripSessionAccessCookies(t *testing.T) {
+	tests := []struct {
+		name     string
+		cookies  []string
+		expected []string
+	}{
+		{name: "no cookies", cookies: nil, expected: nil},
+		{name: "only access cookies", cookies: []string{"demo_access_a=token; demo_access_b=token"}, expected: nil},
+		{name: "mixed cookies", cookies: []string{"theme=dark; demo_access_a=token; lang=en"}, expected: []string{"theme=dark; lang=en"}},
+		{name: "several headers", cookies: []string{"demo_access_a=token", "theme=dark"}, expected: []string{"theme=dark"}},
+		{name: "irregular spacing", cookies: []string{" demo_access_a=token ;theme=dark;; "}, expected: []string{"theme=dark"}},
+		{name: "similar names kept", cookies: []string{"demo_accessory=1; my_demo_access_a=2"}, expected: []string{"demo_accessory=1; my_demo_access_a=2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, cookie := range test.cookies {
+				header.Add("Cookie", cookie)
+			}
+
+			stripSessionAccessCookies(header)
+
+			cookies := header.Values("Cookie")
+			if len(cookies) != len(test.expected) {
+				t.Fatalf("cookies = %q, expected %q", cookies, test.expected)
+			}
+			for index := range cookies {
+				if cookies[index] != test.expected[index] {
+					t.Fatalf("cookies = %q, expected %q", cookies, test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionProxyDropsAccessCookies(t *testing.T) {
+	server := newTestServer(t)
+
+	receivedCookies := make(chan string, 1)
+	dashboard := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		receivedCookies <- request.Header.Get("Cookie")
+		_, _ = io.WriteString(writer, "dashboard")
+	}))
+	defer dashboard.Close()
+
+	session := newSession("cookie-session")
+	session.Phase = SessionPhaseReady
+	session.Healthy = true
+	session.DashboardHost = dashboard.Listener.Addr().String()
+	server.Sessions[session.Id] = session
+
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	ownerToken := server.signSessionToken(session.Id, SessionAccessOwner, time.Now().Add(time.Hour))
+	cookieHeader := sessionAccessCookiePrefix + session.Id + "=" + ownerToken + "; theme=dark; " + sessionAccessCookiePrefix + "other=token"
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "http"},
+		{name: "websocket", headers: map[string]string{
+			"Connection":            "Upgrade",
+			"Upgrade":               "websocket",
+			"Sec-WebSocket-Version": "13",
+			"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, httpServer.URL+"/session/"+session.Id+"/void/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Cookie", cookieHeader)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("proxied request returned %d", response.StatusCode)
+			}
+
+			select {
+			case cookies := <-receivedCookies:
+				if cookies != "theme=dark" {
+					t.Fatalf("dashboard received cookies %q, expected only theme=dark", cookies)
+				}
+			default:
+				t.Fatal("request did not reach the dashboard")
+			}
+		})
+	}
+}
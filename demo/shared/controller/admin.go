@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type adminSessionResponse struct {
+	Id               string                   `json:"id"`
+	SanitizedId      string                   `json:"sanitizedId"`
+	Phase            SessionPhase             `json:"phase"`
+	Phases           []SessionPhaseTransition `json:"phases,omitempty"`
+	Ready            bool                     `json:"ready"`
+	Healthy          bool                     `json:"healthy"`
+	HealthError      string                   `json:"healthError,omitempty"`
+	HealthCheckedUtc *time.Time               `json:"healthCheckedUtc,omitempty"`
+	LastError        string                   `json:"lastError,omitempty"`
+	FailureReason    string                   `json:"failureReason,omitempty"`
+	Pooled           bool                     `json:"pooled"`
+	QueuePosition    int                      `json:"queuePosition,omitempty"`
+	ClientAddress    string                   `json:"clientAddress,omitempty"`
+	DashboardHost    string                   `json:"dashboardHost,omitempty"`
+	ClientHost       string                   `json:"clientHost,omitempty"`
+	VoidHost         string                   `json:"voidHost,omitempty"`
+	CreatedUtc       time.Time                `json:"createdUtc"`
+	ExpiresUtc       time.Time                `json:"expiresUtc"`
+	LastActivityUtc  time.Time                `json:"lastActivityUtc"`
+	AbandonedUtc     *time.Time               `json:"abandonedUtc,omitempty"`
+	ActiveRequests   int                      `json:"activeRequests"`
+	AgeSeconds       int64                    `json:"ageSeconds"`
+	SecondsLeft      int64                    `json:"secondsLeft"`
+	MaxLifetimeLeft  int64                    `json:"maxLifetimeSecondsLeft"`
+	Stopping         bool                     `json:"stopping,omitempty"`
+
+	Degraded          bool                             `json:"degraded"`
+	DegradedReason    string                           `json:"degradedReason,omitempty"`
+	ContainerRestarts int                              `json:"containerRestarts"`
+	Containers        map[string]SessionContainerState `json:"containers,omitempty"`
+
+	Proxy SessionProxyStats `json:"proxy"`
+}
+
+type adminSessionListResponse struct {
+	Sessions       []adminSessionResponse `json:"sessions"`
+	QueueLength    int                    `json:"queueLength"`
+	MaxSessions    int                    `json:"maxSessions"`
+	WarmPoolSize   int                    `json:"warmPoolSize"`
+	RecentFailures []ProvisioningFailure  `json:"recentFailures"`
+}
+
+type ProvisioningFailure struct {
+	SessionId string       `json:"sessionId"`
+	Phase     SessionPhase `json:"phase"`
+	Error     string       `json:"error"`
+	Pooled    bool         `json:"pooled"`
+	FailedUtc time.Time    `json:"failedUtc"`
+}
+
+const recentFailureLimit = 20
+
+func (server *Server) recordFailureLocked(session *Session, err error) {
+	server.RecentFailures = append(server.RecentFailures, ProvisioningFailure{
+		SessionId: session.Id,
+		Phase:     session.Phase,
+		Error:     err.Error(),
+		Pooled:    session.Pooled,
+		FailedUtc: time.Now().UTC(),
+	})
+
+	if overflow := len(server.RecentFailures) - recentFailureLimit; overflow > 0 {
+		server.RecentFailures = slices.Delete(server.RecentFailures, 0, overflow)
+	}
+}
+
+func (server *Server) recentFailures() []ProvisioningFailure {
+	server.SessionsMutex.RLock()
+	failures := slices.Clone(server.RecentFailures)
+	server.SessionsMutex.RUnlock()
+
+	slices.Reverse(failures)
+	return failures
+}
+
+func isBearerTokenValid(request *http.Request, expectedToken string) bool {
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(expectedToken)) == 1
+}
+
+func (server *Server) isAdminAuthorized(request *http.Request) bool {
+	if isBearerTokenValid(request, server.AdminToken) {
+		return true
+	}
+
+	_, password, ok := request.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(server.AdminToken)) == 1
+}
+
+func (server *Server) handleAdminApi(writer http.ResponseWriter, request *http.Request) {
+	// Path: /admin/api/sessions[/<sessionId>[/<action>]]
+	if server.AdminToken == "" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if !server.isAdminAuthorized(request) {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="controller"`)
+		writeAdminError(writer, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	path, ok := strings.CutPrefix(request.URL.Path, "/admin/api/sessions")
+	if !ok {
+		writeAdminError(writer, http.StatusNotFound, "Not found")
+		return
+	}
+
+	path = strings.Trim(path, "/")
+	if path == "" {
+		if request.Method != http.MethodGet {
+			writeAdminError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		writeAdminJson(writer, http.StatusOK, server.adminSessionList())
+		return
+	}
+
+	sessionId, action, _ := strings.Cut(path, "/")
+
+	server.SessionsMutex.RLock()
+	session, ok := server.Sessions[sessionId]
+	server.SessionsMutex.RUnlock()
+
+	if !ok {
+		writeAdminError(writer, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	switch {
+	case action == "" && request.Method == http.MethodGet:
+		writeAdminJson(writer, http.StatusOK, server.adminSession(session))
+	case action == "" && request.Method == http.MethodDelete:
+		server.sessionLogger(session).Warn("Force-deleted by an operator")
+
+		if err := server.deleteSession(session.Id); err != nil {
+			writeAdminError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	case action == "extend" && request.Method == http.MethodPost:
+		extension := server.SessionExtension
+		if secondsValue := request.URL.Query().Get("seconds"); secondsValue != "" {
+			seconds, err := strconv.Atoi(secondsValue)
+			if err != nil || seconds <= 0 {
+				writeAdminError(writer, http.StatusBadRequest, "Invalid seconds")
+				return
+			}
+
+			extension = time.Duration(seconds) * time.Second
+		}
+
+		response, err := server.extendSession(session, extension, false)
+		if err != nil {
+			writeAdminError(writer, http.StatusConflict, err.Error())
+			return
+		}
+
+		writeAdminJson(writer, http.StatusOK, response)
+	case action == "restart-client" && request.Method == http.MethodPost:
+		// Claim the restart under the lock so a second request cannot start another one
+		server.SessionsMutex.Lock()
+		phase := session.Phase
+		if phase == SessionPhaseReady {
+			session.setPhaseLocked(SessionPhaseRestarting)
+		}
+		server.SessionsMutex.Unlock()
+
+		switch phase {
+		case SessionPhaseReady:
+		case SessionPhaseRestarting, SessionPhaseWaitingClientApi, SessionPhaseLaunchingGame, SessionPhaseJoining, SessionPhaseProbing:
+			writeAdminError(writer, http.StatusConflict, "Session is already starting or restarting its client")
+			return
+		default:
+			writeAdminError(writer, http.StatusConflict, "Session is not running")
+			return
+		}
+
+		server.sessionLogger(session).Info("Client restart requested by an operator")
+		go server.restartSessionClient(session)
+
+		writeAdminJson(writer, http.StatusAccepted, server.adminSession(session))
+	case action == "share" && request.Method == http.MethodPost:
+		server.SessionsMutex.RLock()
+		sessionSnapshot := *session
+		server.SessionsMutex.RUnlock()
+
+		server.handleShare(writer, request, &sessionSnapshot)
+	case action == "" || action == "extend" || action == "restart-client" || action == "share":
+		writeAdminError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeAdminError(writer, http.StatusNotFound, "Not found")
+	}
+}
+
+func (server *Server) adminSessionList() adminSessionListResponse {
+	server.SessionsMutex.RLock()
+	sessions := make([]*Session, 0, len(server.Sessions))
+	for _, session := range server.Sessions {
+		sessions = append(sessions, session)
+	}
+	queueLength := len(server.Queue)
+	server.SessionsMutex.RUnlock()
+
+	slices.SortFunc(sessions, func(left *Session, right *Session) int {
+		return left.CreatedUtc.Compare(right.CreatedUtc)
+	})
+
+	response := adminSessionListResponse{
+		Sessions:       make([]adminSessionResponse, 0, len(sessions)),
+		QueueLength:    queueLength,
+		MaxSessions:    server.MaxSessions,
+		WarmPoolSize:   server.WarmPoolSize,
+		RecentFailures: server.recentFailures(),
+	}
+
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, server.adminSession(session))
+	}
+
+	return response
+}
+
+func (server *Server) adminSession(session *Session) adminSessionResponse {
+	server.SessionsMutex.RLock()
+	sessionSnapshot := *session
+	sessionSnapshot.PhaseHistory = slices.Clone(session.PhaseHistory)
+	containers := cloneContainerStatesLocked(session.Containers)
+	queuePosition := server.queuePositionLocked(session.Id)
+	stopping := server.StoppingProjects[session.SanitizedId]
+	server.SessionsMutex.RUnlock()
+
+	session = &sessionSnapshot
+	now := time.Now().UTC()
+
+	response := adminSessionResponse{
+		Id:              session.Id,
+		SanitizedId:     session.SanitizedId,
+		Phase:           session.Phase,
+		Phases:          session.PhaseHistory,
+		Ready:           server.isSessionReady(session),
+		Healthy:         session.Healthy,
+		HealthError:     session.HealthError,
+		LastError:       session.LastError,
+		FailureReason:   session.FailureReason,
+		Pooled:          session.Pooled,
+		QueuePosition:   queuePosition,
+		ClientAddress:   session.ClientAddress,
+		DashboardHost:   session.DashboardHost,
+		ClientHost:      session.ClientHost,
+		VoidHost:        session.VoidHost,
+		CreatedUtc:      session.CreatedUtc,
+		ExpiresUtc:      session.ExpiresUtc,
+		LastActivityUtc: session.LastActivityUtc,
+		ActiveRequests:  session.ActiveRequests,
+		AgeSeconds:      int64(now.Sub(session.CreatedUtc).Seconds()),
+		SecondsLeft:     int64(max(session.ExpiresUtc.Sub(now).Seconds(), 0)),
+		MaxLifetimeLeft: int64(max(server.maxExpiresUtc(session).Sub(now).Seconds(), 0)),
+		Stopping:        stopping,
+
+		Degraded:          session.DegradedReason != "",
+		DegradedReason:    session.DegradedReason,
+		ContainerRestarts: containerRestartsTotal(containers),
+		Containers:        containers,
+
+		Proxy: session.Proxy.stats(),
+	}
+
+	if !session.HealthCheckedUtc.IsZero() {
+		response.HealthCheckedUtc = &session.HealthCheckedUtc
+	}
+	if !session.AbandonedUtc.IsZero() {
+		response.AbandonedUtc = &session.AbandonedUtc
+	}
+
+	return response
+}
+
+func (server *Server) restartSessionClient(session *Session) {
+	server.SessionsMutex.RLock()
+	hosts := SessionHosts{
+		DashboardHost: session.DashboardHost,
+		ClientHost:    session.ClientHost,
+		VoidHost:      session.VoidHost,
+	}
+	server.SessionsMutex.RUnlock()
+
+	clientLogger := func() *slog.Logger {
+		return server.sessionServiceLogger(session, "client")
+	}
+	if err := stopPortableMinecraftClient(hosts.ClientHost, clientLogger); err != nil {
+		clientLogger().Warn("Failed to stop the Minecraft client gracefully", "error", err)
+	}
+
+	setPhase := func(phase SessionPhase) {
+		server.setSessionPhase(session, phase)
+	}
+
+	err := startAndJoinPortableMinecraftClient(hosts.ClientHost, createMinecraftUsername(session.SanitizedId), setPhase, clientLogger)
+	if err == nil {
+		setPhase(SessionPhaseProbing)
+		err = waitForSessionServices(hosts)
+	}
+
+	if err != nil {
+		server.failSession(session, fmt.Errorf("failed to restart client: %w", err))
+		server.stopSession(session)
+
+		server.SessionsMutex.Lock()
+		session.ExpiresUtc = time.Now().UTC().Add(server.FailedSessionGrace)
+		server.SessionsMutex.Unlock()
+
+		server.armDeleteTimer(session)
+		server.persistSessions()
+		server.promoteQueuedSessions()
+		return
+	}
+
+	server.SessionsMutex.Lock()
+	session.Healthy = true
+	session.HealthCheckedUtc = time.Now().UTC()
+	session.LastActivityUtc = session.HealthCheckedUtc
+	session.setPhaseLocked(SessionPhaseReady)
+	server.SessionsMutex.Unlock()
+
+	server.persistSessions()
+	server.sessionLogger(session).Info("Client restarted")
+}
+
+func writeAdminJson(writer http.ResponseWriter, statusCode int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(statusCode)
+	_ = json.NewEncoder(writer).Encode(value)
+}
+
+func writeAdminError(writer http.ResponseWriter, statusCode int, message string) {
+	writeAdminJson(writer, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const adminRefreshSeconds = 5
+
+func (server *Server) handleAdminPage(writer http.ResponseWriter, request *http.Request) {
+	// Path: /admin/, /admin/sessions/<sessionId>/open or /admin/sessions/<sessionId>/delete
+	if server.AdminToken == "" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if !server.isAdminAuthorized(request) {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="controller", charset="UTF-8"`)
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if request.URL.Path == "/admin/" {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		server.writeAdminHtml(writer)
+		return
+	}
+
+	path := strings.TrimPrefix(request.URL.Path, "/admin/sessions/")
+	if sessionId, found := strings.CutSuffix(path, "/open"); path != request.URL.Path && found && sessionId != "" && !strings.Contains(sessionId, "/") {
+		server.handleAdminOpenSession(writer, request, sessionId)
+		return
+	}
+
+	sessionId, found := strings.CutSuffix(path, "/delete")
+	if path == request.URL.Path || !found || sessionId == "" || strings.Contains(sessionId, "/") {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isSameOriginRequest(request) {
+		http.Error(writer, "Cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+
+	server.SessionsMutex.RLock()
+	_, ok := server.Sessions[sessionId]
+	server.SessionsMutex.RUnlock()
+
+	if ok {
+		logger := server.sessionIdLogger(sessionId)
+		logger.Warn("Force-deleted by an operator")
+
+		if err := server.deleteSession(sessionId); err != nil {
+			logger.Error("Failed to delete session from the admin page", "error", err)
+		}
+	}
+
+	http.Redirect(writer, request, "/admin/", http.StatusSeeOther)
+}
+
+func isSameOriginRequest(request *http.Request) bool {
+	if fetchSite := request.Header.Get("Sec-Fetch-Site"); fetchSite != "" {
+		return fetchSite == "same-origin" || fetchSite == "none"
+	}
+
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originUrl, err := url.Parse(origin)
+	return err == nil && originUrl.Host == request.Host
+}
+
+// Operators have no owner cookie, hand them a short lived full access link instead
+func (server *Server) handleAdminOpenSession(writer http.ResponseWriter, request *http.Request, sessionId string) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.SessionsMutex.RLock()
+	session, ok := server.Sessions[sessionId]
+	ok = ok && !session.Pooled
+	server.SessionsMutex.RUnlock()
+
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+
+	token := server.signSessionToken(sessionId, SessionAccessFull, time.Now().UTC().Add(adminOpenLinkLifetime))
+	server.sessionIdLogger(sessionId).Info("Opened by an operator")
+	http.Redirect(writer, request, server.sessionShareUrl(request, sessionId, token), http.StatusFound)
+}
+
+func formatAdminDuration(duration time.Duration) string {
+	duration = max(duration, 0).Round(time.Second)
+
+	switch {
+	case duration >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(duration.Hours()), int(duration.Minutes())%60)
+	case duration >= time.Minute:
+		return fmt.Sprintf("%dm %ds", int(duration.Minutes()), int(duration.Seconds())%60)
+	default:
+		return fmt.Sprintf("%ds", int(duration.Seconds()))
+	}
+}
+
+func (server *Server) writeAdminHtml(writer http.ResponseWriter) {
+	sessions := server.adminSessionList()
+
+	activeSessions := 0
+	sessionRowsHtml := strings.Builder{}
+	for _, session := range sessions.Sessions {
+		if session.Phase != SessionPhaseQueued && session.Phase != SessionPhaseFailed {
+			activeSessions++
+		}
+
+		stateClass := "starting"
+		stateText := string(session.Phase)
+		switch {
+		case session.Stopping:
+			stateClass = "failed"
+			stateText = "stopping"
+		case session.Ready && session.Degraded:
+			stateClass = "failed"
+			stateText = "degraded"
+		case session.Ready && session.AbandonedUtc != nil:
+			stateClass = "starting"
+			stateText = "abandoned"
+		case session.Ready:
+			stateClass = "ready"
+		case session.Phase == SessionPhaseReady:
+			stateClass = "failed"
+			stateText = "unhealthy"
+		case session.Phase == SessionPhaseFailed:
+			stateClass = "failed"
+		case session.Phase == SessionPhaseQueued:
+			stateText = fmt.Sprintf("queued #%d", session.QueuePosition)
+		}
+
+		kindText := "visitor"
+		if session.Pooled {
+			kindText = "warm pool"
+		}
+
+		detailText := session.ClientAddress
+		if session.DegradedReason != "" {
+			detailText = session.DegradedReason
+		} else if session.HealthError != "" && session.Phase == SessionPhaseReady {
+			detailText = session.HealthError
+		} else if session.LastError != "" {
+			detailText = session.LastError
+		}
+
+		actionsHtml := ""
+		sessionIdHtml := html.EscapeString(session.Id)
+		if session.Ready && !session.Pooled {
+			actionsHtml += `<a class="button secondary" href="/admin/sessions/` + sessionIdHtml + `/open" target="_blank" rel="noopener">Open</a>`
+		}
+		actionsHtml += `<form method="post" action="/admin/sessions/` + sessionIdHtml + `/delete" onsubmit="return confirm('Kill this session?')"><button class="button" type="submit">Kill</button></form>`
+
+		fmt.Fprintf(&sessionRowsHtml, `
+          <tr>
+            <td><span class="mono" title="%s">%s</span><span class="muted">%s</span></td>
+            <td><span class="state %s">%s</span></td>
+            <td class="num">%s</td>
+            <td class="num">%s</td>
+            <td class="detail" title="%s">%s</td>
+            <td><div class="actions">%s</div></td>
+          </tr>`,
+			sessionIdHtml, html.EscapeString(session.SanitizedId[:min(12, len(session.SanitizedId))]), html.EscapeString(kindText),
+			stateClass, html.EscapeString(stateText),
+			formatAdminDuration(time.Duration(session.AgeSeconds)*time.Second),
+			formatAdminDuration(time.Duration(session.SecondsLeft)*time.Second),
+			html.EscapeString(detailText), html.EscapeString(detailText),
+			actionsHtml)
+	}
+	if len(sessions.Sessions) == 0 {
+		sessionRowsHtml.WriteString(`
+          <tr><td colspan="6" class="muted">No sessions</td></tr>`)
+	}
+
+	failureRowsHtml := strings.Builder{}
+	for _, failure := range sessions.RecentFailures {
+		kindText := "visitor"
+		if failure.Pooled {
+			kindText = "warm pool"
+		}
+
+		fmt.Fprintf(&failureRowsHtml, `
+          <tr>
+            <td class="num">%s ago</td>
+            <td><span class="mono" title="%s">%s</span><span class="muted">%s</span></td>
+            <td><span class="state failed">%s</span></td>
+            <td class="detail" title="%s">%s</td>
+          </tr>`,
+			formatAdminDuration(time.Since(failure.FailedUtc)),
+			html.EscapeString(failure.SessionId), html.EscapeString(failure.SessionId[:min(12, len(failure.SessionId))]), html.EscapeString(kindText),
+			html.EscapeString(string(failure.Phase)),
+			html.EscapeString(failure.Error), html.EscapeString(failure.Error))
+	}
+	if len(sessions.RecentFailures) == 0 {
+		failureRowsHtml.WriteString(`
+          <tr><td colspan="4" class="muted">No recent failures</td></tr>`)
+	}
+
+	summaryText := fmt.Sprintf("%d of %d slots in use, %d queued, warm pool size %d", activeSessions, sessions.MaxSessions, sessions.QueueLength, sessions.WarmPoolSize)
+	if server.ShuttingDown.Load() {
+		summaryText += ", shutting down"
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+
+	page := fmt.Sprintf(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <meta name="viewport" content="width=device-width, initial-scale=1, viewport-fit=cover"/>
+  <meta http-equiv="refresh" content="%d"/>
+  <title>Demo sessions</title>
+  <style>
+    :root {
+      --bg-color: #0f0b1e;
+      --card-bg: #1a162d;
+      --card-border: #4c2f7a;
+      --text-main: #e9d5ff;
+      --text-muted: #a39eb5;
+      --accent: #d946ef; /* Neon Purple/Pink */
+      --accent-glow: rgba(217, 70, 239, 0.4);
+      --pill-bg: #281f3f;
+    }
+
+    html, body {
+      min-height: 100%%;
+      margin: 0;
+      background-color: var(--bg-color);
+      background-image: radial-gradient(circle at 50%% 0%%, #2e1065 0%%, #0f0b1e 75%%);
+      color: var(--text-main);
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+      -webkit-font-smoothing: antialiased;
+    }
+
+    .wrap {
+      display: flex;
+      flex-direction: column;
+      align-items: center;
+      gap: 20px;
+      padding: 20px;
+      box-sizing: border-box;
+    }
+
+    .card {
+      width: 100%%;
+      max-width: 1080px;
+      background: var(--card-bg);
+      border: 1px solid var(--card-border);
+      border-radius: 20px;
+      padding: 32px;
+      box-sizing: border-box;
+      box-shadow: 0 10px 40px -10px rgba(0, 0, 0, 0.5), 0 0 20px -5px rgba(124, 58, 237, 0.2);
+      overflow-x: auto;
+    }
+
+    h1, h2 {
+      margin: 0 0 16px 0;
+      font-weight: 700;
+      color: #fff;
+      letter-spacing: -0.5px;
+    }
+
+    h1 { font-size: 26px; }
+    h2 { font-size: 20px; }
+
+    p {
+      margin: 0 0 20px 0;
+      line-height: 1.6;
+      color: var(--text-muted);
+      font-size: 16px;
+    }
+
+    table {
+      width: 100%%;
+      border-collapse: collapse;
+      font-size: 14px;
+    }
+
+    th {
+      text-align: left;
+      font-weight: 500;
+      color: var(--text-muted);
+      padding: 8px 10px;
+      border-bottom: 1px solid var(--card-border);
+    }
+
+    td {
+      padding: 10px;
+      border-bottom: 1px solid rgba(255,255,255,0.05);
+      vertical-align: middle;
+    }
+
+    .mono {
+      display: block;
+      font-family: ui-monospace, SFMono-Regular, Menlo, monospace;
+    }
+
+    .muted {
+      display: block;
+      color: #6b7280;
+      font-size: 12px;
+    }
+
+    .num {
+      font-variant-numeric: tabular-nums;
+      white-space: nowrap;
+    }
+
+    .detail {
+      max-width: 320px;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+      color: var(--text-muted);
+    }
+
+    .state {
+      display: inline-block;
+      padding: 4px 10px;
+      border-radius: 999px;
+      background: var(--pill-bg);
+      border: 1px solid var(--card-border);
+      font-size: 12px;
+      white-space: nowrap;
+    }
+
+    .state.ready { color: #a7f3d0; border-color: #a7f3d0; }
+    .state.failed { color: #fca5a5; border-color: #f87171; }
+
+    .actions {
+      display: flex;
+      gap: 8px;
+      justify-content: flex-end;
+    }
+
+    .actions form {
+      margin: 0;
+    }
+
+    .button {
+      appearance: none;
+      padding: 6px 14px;
+      border-radius: 999px;
+      border: 1px solid var(--accent);
+      background: var(--accent);
+      color: #fff;
+      font: inherit;
+      font-weight: 600;
+      font-size: 13px;
+      cursor: pointer;
+      text-decoration: none;
+      box-shadow: 0 0 20px -5px var(--accent-glow);
+      transition: filter 0.2s;
+    }
+
+    .button.secondary {
+      background: transparent;
+    }
+
+    .button:hover {
+      filter: brightness(1.1);
+    }
+  </style>
+</head>
+<body>
+  <div class="wrap">
+    <div class="card">
+      <h1>Demo sessions</h1>
+      <p>%s</p>
+
+      <table>
+        <thead>
+          <tr><th>Session</th><th>State</th><th>Age</th><th>TTL left</th><th>Details</th><th></th></tr>
+        </thead>
+        <tbody>%s
+        </tbody>
+      </table>
+    </div>
+
+    <div class="card">
+      <h2>Recent failures</h2>
+
+      <table>
+        <thead>
+          <tr><th>When</th><th>Session</th><th>Phase</th><th>Error</th></tr>
+        </thead>
+        <tbody>%s
+        </tbody>
+      </table>
+    </div>
+  </div>
+</body>
+</html>`, adminRefreshSeconds, html.EscapeString(summaryText), sessionRowsHtml.String(), failureRowsHtml.String())
+
+	_, _ = writer.Write([]byte(page))
+}
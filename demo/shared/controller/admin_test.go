@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRestartClientRejectsConcurrentRestart(t *testing.T) {
+	server := newTestServer(t)
+	server.AdminToken = "admin"
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	sessionId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+
+	server.SessionsMutex.Lock()
+	session := server.Sessions[sessionId]
+	session.setPhaseLocked(SessionPhaseRestarting)
+	server.SessionsMutex.Unlock()
+
+	request, err := http.NewRequest(http.MethodPost, httpServer.URL+"/admin/api/sessions/"+sessionId+"/restart-client", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer admin")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusConflict {
+		t.Fatalf("restart during a restart returned %d, expected 409", response.StatusCode)
+	}
+
+	server.SessionsMutex.RLock()
+	phase := session.Phase
+	server.SessionsMutex.RUnlock()
+	if phase != SessionPhaseRestarting {
+		t.Fatalf("rejected restart changed the phase to %s", phase)
+	}
+}
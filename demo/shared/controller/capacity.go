@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+func (server *Server) clientAddress(request *http.Request) string {
+	if server.TrustForwardedFor {
+		forwardedAddresses := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+		lastForwardedAddress := strings.TrimSpace(forwardedAddresses[len(forwardedAddresses)-1])
+		if net.ParseIP(lastForwardedAddress) != nil {
+			return lastForwardedAddress
+		}
+	}
+
+	remoteHost, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return remoteHost
+}
+
+func (server *Server) countActiveSessionsLocked() int {
+	activeCount := 0
+	for _, session := range server.Sessions {
+		if session.Phase != SessionPhaseQueued && session.Phase != SessionPhaseFailed {
+			activeCount++
+		}
+	}
+
+	return activeCount
+}
+
+func (server *Server) countClientSessionsLocked(clientAddress string) int {
+	clientCount := 0
+	for _, session := range server.Sessions {
+		if session.ClientAddress == clientAddress && session.Phase != SessionPhaseFailed {
+			clientCount++
+		}
+	}
+
+	return clientCount
+}
+
+func (server *Server) queuePositionLocked(sessionId string) int {
+	return slices.Index(server.Queue, sessionId) + 1
+}
+
+func (server *Server) removeFromQueueLocked(sessionId string) {
+	server.Queue = slices.DeleteFunc(server.Queue, func(queuedSessionId string) bool {
+		return queuedSessionId == sessionId
+	})
+}
+
+func (server *Server) promoteQueuedSessions() {
+	if server.ShuttingDown.Load() {
+		return
+	}
+
+	now := time.Now().UTC()
+	promotedSessions := []*Session{}
+
+	server.SessionsMutex.Lock()
+	for _, sessionId := range slices.Clone(server.Queue) {
+		session, ok := server.Sessions[sessionId]
+		if !ok {
+			server.removeFromQueueLocked(sessionId)
+			continue
+		}
+
+		if server.QueueAbandonTimeout > 0 && now.Sub(session.LastPolledUtc) > server.QueueAbandonTimeout {
+			session.loggerLocked().Info("Abandoned the queue")
+			server.discardSessionLocked(session)
+		}
+	}
+
+	adoptedSessions := []*Session{}
+	for len(server.Queue) > 0 {
+		session := server.Sessions[server.Queue[0]]
+
+		if pooledSession := server.adoptPooledSessionLocked(session); pooledSession != nil {
+			server.Sessions[session.Id] = pooledSession
+			adoptedSessions = append(adoptedSessions, pooledSession)
+			session = pooledSession
+		} else if server.MaxSessions <= 0 || server.countActiveSessionsLocked() < server.MaxSessions {
+			promotedSessions = append(promotedSessions, session)
+		} else {
+			break
+		}
+
+		server.Queue = server.Queue[1:]
+		if session.Phase == SessionPhaseQueued {
+			session.setPhaseLocked(SessionPhaseComposing)
+		}
+	}
+	server.SessionsMutex.Unlock()
+
+	if len(promotedSessions) == 0 && len(adoptedSessions) == 0 {
+		return
+	}
+
+	for _, session := range adoptedSessions {
+		server.sessionLogger(session).Info("Promoted from the queue into a warm pool session")
+		server.armDeleteTimer(session)
+	}
+
+	server.persistSessions()
+
+	for _, session := range promotedSessions {
+		server.sessionLogger(session).Info("Promoted from the queue")
+		go server.provisionSession(session)
+	}
+
+	if len(adoptedSessions) > 0 {
+		go server.refillWarmPool()
+	}
+}
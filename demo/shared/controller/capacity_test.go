@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAbandonedQueuedSessionIsDiscarded(t *testing.T) {
+	server := newTestServer(t)
+	server.MaxSessions = 1
+	server.QueueAbandonTimeout = time.Minute
+
+	session := newSession("queued-session")
+	session.LastPolledUtc = time.Now().UTC().Add(-2 * time.Minute)
+	session.setPhaseLocked(SessionPhaseQueued)
+	server.Sessions[session.Id] = session
+	server.Queue = []string{session.Id}
+
+	_, subscriber, unsubscribe := session.Logs.subscribe("", 0)
+	defer unsubscribe()
+
+	server.promoteQueuedSessions()
+
+	server.SessionsMutex.RLock()
+	_, exists := server.Sessions[session.Id]
+	queueLength := len(server.Queue)
+	server.SessionsMutex.RUnlock()
+	if exists || queueLength != 0 {
+		t.Fatalf("abandoned queued session still exists %v, queue length %d", exists, queueLength)
+	}
+
+	if session.Context.Err() == nil {
+		t.Fatal("abandoned queued session context was not cancelled")
+	}
+
+	select {
+	case _, open := <-subscriber:
+		if open {
+			t.Fatal("log subscriber received a line instead of being closed")
+		}
+	default:
+		t.Fatal("log subscriber of the abandoned queued session was left open")
+	}
+}
+
+func TestSessionQueue(t *testing.T) {
+	server := newTestServer(t)
+	server.MaxSessions = 1
+	server.MaxSessionsPerClient = 1
+
+	newSessionFor := func(clientAddress string) (string, int) {
+		t.Helper()
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = clientAddress + ":40000"
+		recorder := httptest.NewRecorder()
+
+		server.handleNewSession(recorder, request)
+
+		return strings.Trim(strings.TrimPrefix(recorder.Header().Get("Location"), "/session/"), "/"), recorder.Code
+	}
+
+	sessionPhase := func(sessionId string) SessionPhase {
+		server.SessionsMutex.RLock()
+		defer server.SessionsMutex.RUnlock()
+
+		if session, ok := server.Sessions[sessionId]; ok {
+			return session.Phase
+		}
+		return ""
+	}
+
+	waitForReady := func(sessionId string) {
+		t.Helper()
+
+		deadline := time.Now().Add(30 * time.Second)
+		for sessionPhase(sessionId) != SessionPhaseReady {
+			if time.Now().After(deadline) {
+				t.Fatalf("session %s did not become ready, phase %q", sessionId, sessionPhase(sessionId))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	queue := func() []string {
+		server.SessionsMutex.RLock()
+		defer server.SessionsMutex.RUnlock()
+
+		return slices.Clone(server.Queue)
+	}
+
+	firstSessionId, statusCode := newSessionFor("192.0.2.1")
+	if statusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("first session returned %d", statusCode)
+	}
+	if _, statusCode := newSessionFor("192.0.2.1"); statusCode != http.StatusTooManyRequests {
+		t.Fatalf("second session of the same client returned %d, expected 429", statusCode)
+	}
+
+	secondSessionId, _ := newSessionFor("192.0.2.2")
+	thirdSessionId, _ := newSessionFor("192.0.2.3")
+	if expected := []string{secondSessionId, thirdSessionId}; !slices.Equal(queue(), expected) {
+		t.Fatalf("queue = %q, expected %q", queue(), expected)
+	}
+	if _, statusCode := newSessionFor("192.0.2.2"); statusCode != http.StatusTooManyRequests {
+		t.Fatalf("queued client starting another session returned %d, expected 429", statusCode)
+	}
+
+	waitForReady(firstSessionId)
+	if phase := sessionPhase(secondSessionId); phase != SessionPhaseQueued {
+		t.Fatalf("queued session moved to %s while no slot was free", phase)
+	}
+
+	if err := server.deleteSession(firstSessionId); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{thirdSessionId}; !slices.Equal(queue(), expected) {
+		t.Fatalf("queue after the first session ended = %q, expected %q", queue(), expected)
+	}
+	waitForReady(secondSessionId)
+
+	if err := server.deleteSession(secondSessionId); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue()) != 0 {
+		t.Fatalf("queue after the second session ended = %q, expected it empty", queue())
+	}
+	waitForReady(thirdSessionId)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type ContainerEvent struct {
+	Service  string
+	Action   string
+	ExitCode int
+	Time     time.Time
+}
+
+type SessionContainerState struct {
+	Restarts     int         `json:"restarts"`
+	Exited       bool        `json:"exited"`
+	LastExitCode *int        `json:"lastExitCode,omitempty"`
+	LastExitUtc  *time.Time  `json:"lastExitUtc,omitempty"`
+	RecentExits  []time.Time `json:"-"`
+}
+
+var crashLoopServices = []string{"client", "void"}
+
+// The void entrypoint restarts the proxy in a loop instead of exiting and reports every exit on the container output
+const voidProxyExitFormat = "Void proxy exited with code %d at %d"
+
+const (
+	containerEventsRetryMinimum = 1 * time.Second
+	containerEventsRetryMaximum = 30 * time.Second
+)
+
+func (server *Server) watchContainerEvents(session *Session) {
+	retryDelay := containerEventsRetryMinimum
+
+	for {
+		attachedUtc := time.Now().UTC()
+		err := server.Runtime.WatchContainerEvents(session.Context, session, func(event ContainerEvent) {
+			server.handleContainerEvent(session, event)
+		})
+
+		if session.Context.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			server.sessionLogger(session).Debug("Container event stream interrupted", "error", err, "retry_in", retryDelay)
+		}
+
+		if time.Since(attachedUtc) > containerEventsRetryMaximum {
+			retryDelay = containerEventsRetryMinimum
+		}
+
+		select {
+		case <-session.Context.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+
+		retryDelay = min(retryDelay*2, containerEventsRetryMaximum)
+	}
+}
+
+func (server *Server) handleContainerEvent(session *Session, event ContainerEvent) {
+	server.SessionsMutex.Lock()
+	if session.Context.Err() != nil || server.StoppingProjects[session.SanitizedId] {
+		server.SessionsMutex.Unlock()
+		return
+	}
+
+	if session.Containers == nil {
+		session.Containers = map[string]*SessionContainerState{}
+	}
+
+	state, ok := session.Containers[event.Service]
+	if !ok {
+		state = &SessionContainerState{}
+		session.Containers[event.Service] = state
+	}
+
+	restarted := false
+	switch event.Action {
+	case "die", "restart":
+		exitCode := event.ExitCode
+		exitUtc := event.Time
+		state.LastExitCode = &exitCode
+		state.LastExitUtc = &exitUtc
+		state.RecentExits = append(state.RecentExits, exitUtc)
+		// A process restarted inside its container is running again by the time the exit is reported
+		if event.Action == "restart" {
+			state.Restarts++
+			restarted = true
+		} else {
+			state.Exited = true
+		}
+	case "start":
+		restarted = state.Exited
+		if restarted {
+			state.Restarts++
+		}
+		state.Exited = false
+	}
+
+	wasDegraded := session.DegradedReason != ""
+	server.refreshDegradedLocked(session)
+	degradedReason := session.DegradedReason
+	logger := session.loggerLocked()
+	server.SessionsMutex.Unlock()
+
+	switch event.Action {
+	case "die", "restart":
+		if restarted {
+			logger.Warn("Process restarted inside the container", "service", event.Service, "exit_code", event.ExitCode)
+			controllerMetrics.ContainerRestarts.inc(event.Service)
+		} else {
+			logger.Warn("Container exited", "service", event.Service, "exit_code", event.ExitCode)
+		}
+		// Re-evaluate once this exit falls out of the crash loop window so a recovered session clears itself
+		time.AfterFunc(time.Until(event.Time.Add(server.CrashLoopWindow)), func() {
+			server.refreshDegraded(session)
+		})
+	case "start":
+		if restarted {
+			logger.Info("Container restarted", "service", event.Service)
+			controllerMetrics.ContainerRestarts.inc(event.Service)
+		}
+	}
+
+	switch {
+	case !wasDegraded && degradedReason != "":
+		logger.Warn("Session is degraded", "reason", degradedReason)
+	case wasDegraded && degradedReason == "":
+		logger.Info("Session is no longer degraded")
+	}
+
+	session.Watcher.notify()
+}
+
+func parseVoidProxyExit(line string) (ContainerEvent, bool) {
+	var exitCode int
+	var exitUnix int64
+	if _, err := fmt.Sscanf(line, voidProxyExitFormat, &exitCode, &exitUnix); err != nil {
+		return ContainerEvent{}, false
+	}
+
+	return ContainerEvent{Service: "void", Action: "restart", ExitCode: exitCode, Time: time.Unix(exitUnix, 0).UTC()}, true
+}
+
+func (server *Server) refreshDegraded(session *Session) {
+	server.SessionsMutex.Lock()
+	wasDegraded := session.DegradedReason != ""
+	server.refreshDegradedLocked(session)
+	recovered := wasDegraded && session.DegradedReason == ""
+	logger := session.loggerLocked()
+	server.SessionsMutex.Unlock()
+
+	if recovered {
+		logger.Info("Session is no longer degraded")
+		session.Watcher.notify()
+	}
+}
+
+func (server *Server) refreshDegradedLocked(session *Session) {
+	cutoffUtc := time.Now().UTC().Add(-server.CrashLoopWindow)
+
+	session.DegradedReason = ""
+	for _, service := range crashLoopServices {
+		state, ok := session.Containers[service]
+		if !ok {
+			continue
+		}
+
+		state.RecentExits = slices.DeleteFunc(state.RecentExits, func(exitUtc time.Time) bool {
+			return exitUtc.Before(cutoffUtc)
+		})
+
+		if server.CrashLoopRestarts > 0 && len(state.RecentExits) >= server.CrashLoopRestarts && session.DegradedReason == "" {
+			session.DegradedReason = fmt.Sprintf("%s container exited %d times in the last %s", service, len(state.RecentExits), server.CrashLoopWindow)
+		}
+	}
+}
+
+func cloneContainerStatesLocked(containers map[string]*SessionContainerState) map[string]SessionContainerState {
+	cloned := make(map[string]SessionContainerState, len(containers))
+	for service, state := range containers {
+		stateCopy := *state
+		stateCopy.RecentExits = slices.Clone(state.RecentExits)
+		cloned[service] = stateCopy
+	}
+
+	return cloned
+}
+
+func containerRestartsTotal(containers map[string]SessionContainerState) int {
+	total := 0
+	for _, state := range containers {
+		total += state.Restarts
+	}
+
+	return total
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseVoidProxyExit(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		exitCode int
+		exitUtc  time.Time
+		ok       bool
+	}{
+		{name: "exit", line: "Void proxy exited with code 134 at 1760000000", exitCode: 134, exitUtc: time.Unix(1760000000, 0).UTC(), ok: true},
+		{name: "clean exit", line: "Void proxy exited with code 0 at 1760000000", exitCode: 0, exitUtc: time.Unix(1760000000, 0).UTC(), ok: true},
+		{name: "proxy output", line: "[12:00:00 INF] Void.Proxy started"},
+		{name: "missing time", line: "Void proxy exited with code 1"},
+		{name: "empty", line: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := parseVoidProxyExit(test.line)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, expected %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+
+			if event.Service != "void" || event.Action != "restart" || event.ExitCode != test.exitCode || !event.Time.Equal(test.exitUtc) {
+				t.Fatalf("event = %+v, expected a void restart with code %d at %s", event, test.exitCode, test.exitUtc)
+			}
+		})
+	}
+}
+
+func TestCrashLoopDetection(t *testing.T) {
+	type containerInput struct {
+		event   ContainerEvent
+		logLine string
+	}
+
+	crash := func(service string, exitCode int, exitedAgo time.Duration) []containerInput {
+		exitUtc := time.Now().UTC().Add(-exitedAgo)
+		return []containerInput{
+			{event: ContainerEvent{Service: service, Action: "die", ExitCode: exitCode, Time: exitUtc}},
+			{event: ContainerEvent{Service: service, Action: "start", Time: exitUtc}},
+		}
+	}
+	proxyRestart := func(exitCode int, exitedAgo time.Duration) []containerInput {
+		return []containerInput{{logLine: fmt.Sprintf(voidProxyExitFormat, exitCode, time.Now().Add(-exitedAgo).Unix())}}
+	}
+	repeat := func(count int, inputs func() []containerInput) []containerInput {
+		repeated := []containerInput{}
+		for range count {
+			repeated = append(repeated, inputs()...)
+		}
+		return repeated
+	}
+
+	tests := []struct {
+		name     string
+		inputs   []containerInput
+		service  string
+		restarts int
+		exitCode int
+		degraded bool
+	}{
+		{
+			name:     "client crash loop",
+			inputs:   repeat(3, func() []containerInput { return crash("client", 1, 0) }),
+			service:  "client",
+			restarts: 3,
+			exitCode: 1,
+			degraded: true,
+		},
+		{
+			name:     "client restarts below the threshold",
+			inputs:   repeat(2, func() []containerInput { return crash("client", 1, 0) }),
+			service:  "client",
+			restarts: 2,
+			exitCode: 1,
+		},
+		{
+			name:     "client exits outside the window",
+			inputs:   append(repeat(2, func() []containerInput { return crash("client", 1, 10*time.Minute) }), crash("client", 2, 0)...),
+			service:  "client",
+			restarts: 3,
+			exitCode: 2,
+		},
+		{
+			name:     "dashboard is not crash looping",
+			inputs:   repeat(3, func() []containerInput { return crash("dashboard", 137, 0) }),
+			service:  "dashboard",
+			restarts: 3,
+			exitCode: 137,
+		},
+		{
+			name:     "void container crash loop",
+			inputs:   repeat(3, func() []containerInput { return crash("void", 139, 0) }),
+			service:  "void",
+			restarts: 3,
+			exitCode: 139,
+			degraded: true,
+		},
+		{
+			name:     "void proxy restarting inside the container",
+			inputs:   append([]containerInput{{logLine: "[12:00:00 INF] Void.Proxy started"}}, repeat(3, func() []containerInput { return proxyRestart(134, 0) })...),
+			service:  "void",
+			restarts: 3,
+			exitCode: 134,
+			degraded: true,
+		},
+		{
+			name:     "void proxy restarts replayed from old output",
+			inputs:   repeat(3, func() []containerInput { return proxyRestart(134, 10*time.Minute) }),
+			service:  "void",
+			restarts: 3,
+			exitCode: 134,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			httpServer := httptest.NewServer(server.handler())
+			defer httpServer.Close()
+
+			sessionId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+
+			server.SessionsMutex.RLock()
+			session := server.Sessions[sessionId]
+			server.SessionsMutex.RUnlock()
+
+			runtime := server.Runtime.(*FakeRuntime)
+			for _, input := range test.inputs {
+				var err error
+				if input.logLine != "" {
+					err = runtime.writeLogLine(session, "void", input.logLine)
+				} else {
+					err = runtime.sendContainerEvent(session, input.event)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				server.SessionsMutex.RLock()
+				state := cloneContainerStatesLocked(session.Containers)[test.service]
+				degradedReason := session.DegradedReason
+				server.SessionsMutex.RUnlock()
+
+				if state.Restarts == test.restarts && state.LastExitCode != nil {
+					if *state.LastExitCode != test.exitCode {
+						t.Fatalf("last exit code = %d, expected %d", *state.LastExitCode, test.exitCode)
+					}
+					if (degradedReason != "") != test.degraded {
+						t.Fatalf("degraded reason = %q, expected degraded %v", degradedReason, test.degraded)
+					}
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%s restarts = %d, expected %d", test.service, state.Restarts, test.restarts)
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (server *Server) isSessionReady(session *Session) bool {
+	return session.Phase == SessionPhaseReady && session.Healthy
+}
+
+func (server *Server) runHealthMonitor(session *Session) {
+	interval := server.HealthProbeInterval
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		server.SessionsMutex.RLock()
+		hosts := SessionHosts{
+			DashboardHost: session.DashboardHost,
+			ClientHost:    session.ClientHost,
+			VoidHost:      session.VoidHost,
+		}
+		server.SessionsMutex.RUnlock()
+
+		probeError := probeSessionServices(hosts)
+
+		server.SessionsMutex.Lock()
+		wasHealthy := session.Healthy
+		session.Healthy = probeError == nil
+		session.HealthCheckedUtc = time.Now().UTC()
+		session.HealthError = ""
+		if probeError != nil {
+			session.HealthError = probeError.Error()
+		}
+		logger := session.loggerLocked()
+		server.SessionsMutex.Unlock()
+
+		switch {
+		case wasHealthy && probeError != nil:
+			logger.Warn("Became unhealthy", "error", probeError)
+			session.Watcher.notify()
+		case !wasHealthy && probeError == nil:
+			logger.Info("Is healthy again")
+			session.Watcher.notify()
+		}
+
+		if probeError != nil {
+			interval = min(interval*2, max(server.HealthProbeMaxInterval, server.HealthProbeInterval))
+		} else {
+			interval = server.HealthProbeInterval
+		}
+
+		select {
+		case <-session.Context.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func probeSessionServices(hosts SessionHosts) error {
+	httpClient := &http.Client{
+		Timeout: time.Second,
+	}
+
+	probe := func(service string, host string, path string) error {
+		if strings.TrimSpace(host) == "" {
+			return fmt.Errorf("%s host is still empty", service)
+		}
+
+		request, err := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create %s probe request: %w", service, err)
+		}
+
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return fmt.Errorf("%s probe failed: %w", service, err)
+		}
+
+		_ = response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s probe returned %d", service, response.StatusCode)
+		}
+
+		return nil
+	}
+
+	err := probe("dashboard", hosts.DashboardHost, "/")
+	if err == nil {
+		err = probe("void", hosts.VoidHost, "/")
+	}
+	if err == nil {
+		err = probe("client", hosts.ClientHost, "/api/health")
+	}
+
+	if err != nil {
+		controllerMetrics.HealthProbes.inc("failure")
+	} else {
+		controllerMetrics.HealthProbes.inc("success")
+	}
+
+	return err
+}
+
+func waitForSessionServices(hosts SessionHosts) error {
+	deadline := time.Now().Add(2 * time.Minute)
+	lastError := fmt.Errorf("no probe attempted")
+
+	for time.Now().Before(deadline) {
+		lastError = probeSessionServices(hosts)
+		if lastError == nil {
+			return nil
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return fmt.Errorf("session services did not become reachable within 2 minutes: %w", lastError)
+}
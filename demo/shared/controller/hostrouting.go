@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+func normalizeDemoDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Only the Host header is trusted, forwarded host headers are ignored so a visitor cannot pick another session
+func parseSessionHostLabel(host string, demoDomain string) (string, bool) {
+	if demoDomain == "" {
+		return "", false
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	label, found := strings.CutSuffix(host, "."+demoDomain)
+	if !found || label == "" || len(label) > 63 {
+		return "", false
+	}
+
+	for _, ch := range label {
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' {
+			return "", false
+		}
+	}
+
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return "", false
+	}
+
+	return label, true
+}
+
+func (server *Server) withSessionHostRouting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		label, ok := parseSessionHostLabel(request.Host, server.DemoDomain)
+		if !ok {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		server.SessionsMutex.RLock()
+		sessionId := server.sessionIdForSanitizedIdLocked(label)
+		server.SessionsMutex.RUnlock()
+
+		if sessionId == "" {
+			http.Redirect(writer, request, server.demoDomainUrl(request, "/"), http.StatusFound)
+			return
+		}
+
+		restPath := request.URL.Path
+		if restPath == "" {
+			restPath = "/"
+		}
+
+		server.serveSession(writer, request, sessionId, restPath, true)
+	})
+}
+
+func (server *Server) sessionIdForSanitizedIdLocked(sanitizedId string) string {
+	for _, session := range server.Sessions {
+		if session.SanitizedId == sanitizedId && !session.Pooled {
+			return session.Id
+		}
+	}
+
+	return ""
+}
+
+// Protocol relative and on the same port so redirects keep however the visitor reached the controller
+func (server *Server) demoDomainUrl(request *http.Request, path string) string {
+	return "//" + server.DemoDomain + requestPortSuffix(request) + path
+}
+
+func (server *Server) sessionHostUrl(request *http.Request, session *Session) string {
+	return "//" + session.SanitizedId + "." + server.DemoDomain + requestPortSuffix(request) + "/"
+}
+
+func requestPortSuffix(request *http.Request) string {
+	if _, port, err := net.SplitHostPort(request.Host); err == nil && port != "" {
+		return ":" + port
+	}
+
+	return ""
+}
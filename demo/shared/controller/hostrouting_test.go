@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSessionHostLabel(t *testing.T) {
+	longestLabel := strings.Repeat("a", 63)
+
+	tests := []struct {
+		name       string
+		host       string
+		demoDomain string
+		label      string
+		ok         bool
+	}{
+		{name: "session host", host: "abc-123.example.com", demoDomain: "example.com", label: "abc-123", ok: true},
+		{name: "with port", host: "abc.example.com:8080", demoDomain: "example.com", label: "abc", ok: true},
+		{name: "trailing dot", host: "abc.example.com.", demoDomain: "example.com", label: "abc", ok: true},
+		{name: "trailing dot with port", host: "abc.example.com.:443", demoDomain: "example.com", label: "abc", ok: true},
+		{name: "uppercase", host: "ABC.Example.COM", demoDomain: "example.com", label: "abc", ok: true},
+		{name: "localhost domain", host: "abc.localhost:8080", demoDomain: "localhost", label: "abc", ok: true},
+		{name: "longest label", host: longestLabel + ".example.com", demoDomain: "example.com", label: longestLabel, ok: true},
+		{name: "routing disabled", host: "abc.example.com", demoDomain: ""},
+		{name: "bare domain", host: "example.com", demoDomain: "example.com"},
+		{name: "bare domain with port", host: "example.com:8080", demoDomain: "example.com"},
+		{name: "empty label", host: ".example.com", demoDomain: "example.com"},
+		{name: "nested labels", host: "a.b.example.com", demoDomain: "example.com"},
+		{name: "other domain", host: "abc.example.org", demoDomain: "example.com"},
+		{name: "domain as label prefix", host: "abc.example.com.evil.test", demoDomain: "example.com"},
+		{name: "domain without separator", host: "evilexample.com", demoDomain: "example.com"},
+		{name: "underscore", host: "ab_c.example.com", demoDomain: "example.com"},
+		{name: "percent encoded", host: "ab%2e.example.com", demoDomain: "example.com"},
+		{name: "non ascii", host: "abç.example.com", demoDomain: "example.com"},
+		{name: "leading hyphen", host: "-abc.example.com", demoDomain: "example.com"},
+		{name: "trailing hyphen", host: "abc-.example.com", demoDomain: "example.com"},
+		{name: "label over 63 characters", host: longestLabel + "a.example.com", demoDomain: "example.com"},
+		{name: "ip address", host: "127.0.0.1:8080", demoDomain: "example.com"},
+		{name: "ipv6 address", host: "[::1]:8080", demoDomain: "example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			label, ok := parseSessionHostLabel(test.host, test.demoDomain)
+			if label != test.label || ok != test.ok {
+				t.Fatalf("parseSessionHostLabel(%q, %q) = %q, %v, expected %q, %v", test.host, test.demoDomain, label, ok, test.label, test.ok)
+			}
+		})
+	}
+}
+
+func TestSessionHostRouting(t *testing.T) {
+	server := newTestServer(t)
+	server.DemoDomain = "example.com"
+
+	readySession := newSession("Ready_Session")
+	readySession.Phase = SessionPhaseReady
+	readySession.Healthy = true
+
+	pooledSession := newSession("Pooled_Session")
+	pooledSession.Phase = SessionPhaseReady
+	pooledSession.Healthy = true
+	pooledSession.Pooled = true
+
+	server.Sessions[readySession.Id] = readySession
+	server.Sessions[pooledSession.Id] = pooledSession
+
+	tests := []struct {
+		name           string
+		host           string
+		forwardedHost  string
+		passedThrough  bool
+		statusCode     int
+		location       string
+		sessionRouting bool
+	}{
+		{name: "main domain", host: "example.com", passedThrough: true},
+		{name: "forwarded host ignored", host: "example.com", forwardedHost: readySession.SanitizedId + ".example.com", passedThrough: true},
+		{name: "forwarded host cannot switch sessions", host: "unknown.example.com", forwardedHost: readySession.SanitizedId + ".example.com", statusCode: http.StatusFound, location: "//example.com/"},
+		{name: "unknown label", host: "unknown.example.com", statusCode: http.StatusFound, location: "//example.com/"},
+		{name: "unknown label keeps port", host: "unknown.example.com:8080", statusCode: http.StatusFound, location: "//example.com:8080/"},
+		{name: "pooled session", host: pooledSession.SanitizedId + ".example.com", statusCode: http.StatusFound, location: "//example.com/"},
+		{name: "ready session", host: readySession.SanitizedId + ".example.com", statusCode: http.StatusForbidden, sessionRouting: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			passedThrough := false
+			handler := server.withSessionHostRouting(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				passedThrough = true
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Host = test.host
+			if test.forwardedHost != "" {
+				request.Header.Set("X-Forwarded-Host", test.forwardedHost)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if passedThrough != test.passedThrough {
+				t.Fatalf("passed through = %v, expected %v", passedThrough, test.passedThrough)
+			}
+			if test.passedThrough {
+				return
+			}
+
+			if recorder.Code != test.statusCode {
+				t.Fatalf("status = %d, expected %d", recorder.Code, test.statusCode)
+			}
+			if location := recorder.Header().Get("Location"); location != test.location {
+				t.Fatalf("location = %q, expected %q", location, test.location)
+			}
+			// Requests without the owner cookie reach the session and are turned away there
+			if test.sessionRouting && !strings.Contains(recorder.Body.String(), "belongs to someone else") {
+				t.Fatalf("request was not routed to the session: %s", recorder.Body.String())
+			}
+		})
+	}
+}
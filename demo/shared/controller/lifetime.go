@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (server *Server) maxExpiresUtc(session *Session) time.Time {
+	return session.CreatedUtc.Add(server.MaxSessionLifetime)
+}
+
+type sessionExtendResponse struct {
+	Extended    bool      `json:"extended"`
+	ExpiresUtc  time.Time `json:"expiresUtc"`
+	SecondsLeft int64     `json:"secondsLeft"`
+	CanExtend   bool      `json:"canExtend"`
+}
+
+func (server *Server) extendSession(session *Session, extension time.Duration, enforceMaxLifetime bool) (sessionExtendResponse, error) {
+	server.SessionsMutex.Lock()
+	if session.Phase != SessionPhaseReady {
+		server.SessionsMutex.Unlock()
+		return sessionExtendResponse{}, fmt.Errorf("session is not running")
+	}
+
+	maxExpiresUtc := server.maxExpiresUtc(session)
+	previousExpiresUtc := session.ExpiresUtc
+	// Extending reclaims an abandoned session, otherwise the next reclaim would restore the expiry from before the extension
+	if !session.AbandonedUtc.IsZero() {
+		session.AbandonedUtc = time.Time{}
+		session.ExpiresUtc = session.ExpiresBeforeAbandon
+	}
+	session.ExpiresUtc = session.ExpiresUtc.Add(extension)
+	if enforceMaxLifetime && session.ExpiresUtc.After(maxExpiresUtc) {
+		session.ExpiresUtc = maxExpiresUtc
+	}
+	if session.ExpiresUtc.Before(previousExpiresUtc) {
+		session.ExpiresUtc = previousExpiresUtc
+	}
+	session.LastActivityUtc = time.Now().UTC()
+
+	response := sessionExtendResponse{
+		Extended:    session.ExpiresUtc.After(previousExpiresUtc),
+		ExpiresUtc:  session.ExpiresUtc,
+		SecondsLeft: int64(max(time.Until(session.ExpiresUtc).Seconds(), 0)),
+		CanExtend:   session.ExpiresUtc.Before(maxExpiresUtc),
+	}
+	server.SessionsMutex.Unlock()
+
+	if response.Extended {
+		server.armDeleteTimer(session)
+		server.persistSessions()
+		session.Watcher.notify()
+		server.sessionLogger(session).Info("Extended", "expires_utc", response.ExpiresUtc)
+	}
+
+	return response, nil
+}
+
+func (server *Server) handleExtend(writer http.ResponseWriter, session *Session) {
+	response, err := server.extendSession(session, server.SessionExtension, true)
+	if err != nil {
+		http.Error(writer, "Session is not running", http.StatusConflict)
+		return
+	}
+
+	statusCode := http.StatusOK
+	if !response.Extended {
+		statusCode = http.StatusConflict
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func (server *Server) endSession(session *Session) {
+	server.SessionsMutex.RLock()
+	clientHost := session.ClientHost
+	ready := session.Phase == SessionPhaseReady
+	server.SessionsMutex.RUnlock()
+
+	server.sessionLogger(session).Info("Ended by the visitor")
+
+	if ready {
+		clientLogger := func() *slog.Logger {
+			return server.sessionServiceLogger(session, "client")
+		}
+		if err := stopPortableMinecraftClient(clientHost, clientLogger); err != nil {
+			clientLogger().Warn("Failed to stop the Minecraft client gracefully", "error", err)
+		}
+	}
+
+	if err := server.deleteSession(session.Id); err != nil {
+		server.sessionLogger(session).Error("Failed to delete ended session", "error", err)
+	}
+}
+
+func (server *Server) abandonSession(session *Session) {
+	server.SessionsMutex.Lock()
+	abandonExpiresUtc := time.Now().UTC().Add(server.AbandonGrace)
+	if session.Phase != SessionPhaseReady || !session.AbandonedUtc.IsZero() || !abandonExpiresUtc.Before(session.ExpiresUtc) {
+		server.SessionsMutex.Unlock()
+		return
+	}
+
+	// Another tab or a share holder is still using the session
+	if session.inUseLocked() {
+		session.loggerLocked().Debug("Ignored abandon while the session is in use", "active_requests", session.ActiveRequests)
+		server.SessionsMutex.Unlock()
+		return
+	}
+
+	session.AbandonedUtc = time.Now().UTC()
+	session.ExpiresBeforeAbandon = session.ExpiresUtc
+	session.ExpiresUtc = abandonExpiresUtc
+	server.SessionsMutex.Unlock()
+
+	server.sessionLogger(session).Info("Abandoned, expiring unless the visitor returns", "grace", server.AbandonGrace)
+	server.armDeleteTimer(session)
+	session.Watcher.notify()
+}
+
+func (server *Server) reclaimAbandonedSession(session *Session) {
+	server.SessionsMutex.Lock()
+	if session.AbandonedUtc.IsZero() {
+		server.SessionsMutex.Unlock()
+		return
+	}
+
+	session.AbandonedUtc = time.Time{}
+	session.ExpiresUtc = session.ExpiresBeforeAbandon
+	server.SessionsMutex.Unlock()
+
+	server.sessionLogger(session).Info("Visitor returned, restoring expiry")
+	server.armDeleteTimer(session)
+	session.Watcher.notify()
+}
+
+func (session *Session) inUseLocked() bool {
+	return session.ActiveRequests > 0 || session.Proxy.stats().OpenWebSockets > 0
+}
+
+func (server *Server) trackSessionActivity(session *Session, started bool) {
+	server.SessionsMutex.Lock()
+	defer server.SessionsMutex.Unlock()
+
+	if started {
+		session.ActiveRequests++
+	} else {
+		session.ActiveRequests--
+	}
+	session.LastActivityUtc = time.Now().UTC()
+}
+
+func (server *Server) runIdleSweeper() {
+	if server.IdleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(server.IdleTimeout/4, 30*time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now().UTC()
+		idleSessions := []*Session{}
+
+		server.SessionsMutex.RLock()
+		for _, session := range server.Sessions {
+			if session.Phase != SessionPhaseReady || session.Pooled || session.ActiveRequests > 0 {
+				continue
+			}
+
+			if now.Sub(session.LastActivityUtc) > server.IdleTimeout {
+				idleSessions = append(idleSessions, session)
+			}
+		}
+		server.SessionsMutex.RUnlock()
+
+		for _, session := range idleSessions {
+			logger := server.sessionLogger(session)
+			logger.Info("Idle for too long, deleting", "idle_timeout", server.IdleTimeout)
+			controllerMetrics.SessionsExpired.inc("idle")
+
+			if err := server.deleteSession(session.Id); err != nil {
+				logger.Error("Failed to delete idle session", "error", err)
+			}
+		}
+	}
+}
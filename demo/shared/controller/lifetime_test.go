@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestReadySession(server *Server, sessionId string) *Session {
+	session := newSession(sessionId)
+	session.Phase = SessionPhaseReady
+	session.Healthy = true
+	session.ExpiresUtc = time.Now().UTC().Add(time.Hour)
+	session.DashboardHost = "127.0.0.1:1"
+	session.Proxy = server.newSessionProxy(session.Id, session.DashboardHost)
+
+	server.SessionsMutex.Lock()
+	server.Sessions[session.Id] = session
+	server.SessionsMutex.Unlock()
+
+	return session
+}
+
+func openTestTunnel(t *testing.T, session *Session) {
+	t.Helper()
+
+	clientConn, upstreamConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = upstreamConn.Close()
+	})
+
+	if !session.Proxy.registerTunnel(&sessionTunnel{Upstream: "vnc", OpenedUtc: time.Now().UTC(), ClientConn: clientConn, UpstreamConn: upstreamConn}) {
+		t.Fatal("failed to register the test tunnel")
+	}
+}
+
+func TestAbandonSessionSkipsSessionsInUse(t *testing.T) {
+	tests := []struct {
+		name           string
+		activeRequests int
+		openTunnel     bool
+		abandoned      bool
+	}{
+		{name: "idle session", abandoned: true},
+		{name: "request in flight", activeRequests: 1},
+		{name: "websocket open", openTunnel: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			session := newTestReadySession(server, "abandon-session")
+			session.ActiveRequests = test.activeRequests
+			if test.openTunnel {
+				openTestTunnel(t, session)
+			}
+			expiresUtc := session.ExpiresUtc
+
+			server.abandonSession(session)
+
+			server.SessionsMutex.RLock()
+			abandoned := !session.AbandonedUtc.IsZero()
+			currentExpiresUtc := session.ExpiresUtc
+			server.SessionsMutex.RUnlock()
+
+			if abandoned != test.abandoned {
+				t.Fatalf("abandoned = %v, expected %v", abandoned, test.abandoned)
+			}
+			if !abandoned && !currentExpiresUtc.Equal(expiresUtc) {
+				t.Fatalf("skipped abandon changed the expiry from %s to %s", expiresUtc, currentExpiresUtc)
+			}
+		})
+	}
+}
+
+func TestAbandonedSessionReclaimedByStatusPoll(t *testing.T) {
+	server := newTestServer(t)
+	session := newTestReadySession(server, "abandon-session")
+	expiresUtc := session.ExpiresUtc
+
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	sessionRequest := func(method string, restPath string, access SessionAccess) int {
+		t.Helper()
+
+		request, err := http.NewRequest(method, httpServer.URL+"/session/"+session.Id+restPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Cookie", sessionAccessCookiePrefix+session.Id+"="+server.signSessionToken(session.Id, access, time.Now().Add(time.Hour)))
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		return response.StatusCode
+	}
+
+	abandoned := func() bool {
+		server.SessionsMutex.RLock()
+		defer server.SessionsMutex.RUnlock()
+
+		return !session.AbandonedUtc.IsZero()
+	}
+
+	if statusCode := sessionRequest(http.MethodPost, "/abandon", SessionAccessFull); statusCode != http.StatusForbidden || abandoned() {
+		t.Fatalf("share holder abandon returned %d, abandoned %v", statusCode, abandoned())
+	}
+
+	if statusCode := sessionRequest(http.MethodPost, "/abandon", SessionAccessOwner); statusCode != http.StatusNoContent || !abandoned() {
+		t.Fatalf("owner abandon returned %d, abandoned %v", statusCode, abandoned())
+	}
+
+	if statusCode := sessionRequest(http.MethodGet, "/status", SessionAccessView); statusCode != http.StatusOK || abandoned() {
+		t.Fatalf("status poll returned %d, abandoned %v", statusCode, abandoned())
+	}
+
+	server.SessionsMutex.RLock()
+	restoredExpiresUtc := session.ExpiresUtc
+	server.SessionsMutex.RUnlock()
+	if !restoredExpiresUtc.Equal(expiresUtc) {
+		t.Fatalf("reclaimed expiry is %s, expected %s", restoredExpiresUtc, expiresUtc)
+	}
+}
+
+func TestAbandonedSessionInUseSurvivesGrace(t *testing.T) {
+	server := newTestServer(t)
+	server.AbandonGrace = 50 * time.Millisecond
+	session := newTestReadySession(server, "abandon-session")
+
+	server.abandonSession(session)
+
+	server.SessionsMutex.Lock()
+	if session.AbandonedUtc.IsZero() {
+		server.SessionsMutex.Unlock()
+		t.Fatal("idle session was not abandoned")
+	}
+	session.ActiveRequests = 1
+	server.SessionsMutex.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.SessionsMutex.RLock()
+		_, exists := server.Sessions[session.Id]
+		reclaimed := session.AbandonedUtc.IsZero()
+		server.SessionsMutex.RUnlock()
+
+		if !exists {
+			t.Fatal("session in use was deleted after the abandon grace")
+		}
+		if reclaimed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session in use was not reclaimed after the abandon grace")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExtendAbandonedSession(t *testing.T) {
+	server := newTestServer(t)
+	session := newTestReadySession(server, "abandon-session")
+	expiresUtc := session.ExpiresUtc
+
+	server.abandonSession(session)
+	if session.AbandonedUtc.IsZero() {
+		t.Fatal("idle session was not abandoned")
+	}
+
+	response, err := server.extendSession(session, server.SessionExtension, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedExpiresUtc := expiresUtc.Add(server.SessionExtension)
+	if !response.Extended || !response.ExpiresUtc.Equal(expectedExpiresUtc) {
+		t.Fatalf("extend returned %+v, expected the expiry to move to %s", response, expectedExpiresUtc)
+	}
+
+	server.reclaimAbandonedSession(session)
+
+	server.SessionsMutex.RLock()
+	abandoned := !session.AbandonedUtc.IsZero()
+	currentExpiresUtc := session.ExpiresUtc
+	server.SessionsMutex.RUnlock()
+
+	if abandoned {
+		t.Fatal("extended session is still abandoned")
+	}
+	if !currentExpiresUtc.Equal(expectedExpiresUtc) {
+		t.Fatalf("expiry after a reclaim is %s, the extension to %s was undone", currentExpiresUtc, expectedExpiresUtc)
+	}
+}
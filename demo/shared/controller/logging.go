@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnvString("LOG_LEVEL", "info"))); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attribute slog.Attr) slog.Attr {
+			if attribute.Value.Kind() == slog.KindDuration {
+				return slog.String(attribute.Key, attribute.Value.Duration().String())
+			}
+			return attribute
+		},
+	}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(getEnvString("LOG_FORMAT", "text")); format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q, expected text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func (session *Session) loggerLocked() *slog.Logger {
+	return slog.With("session_id", session.Id, "phase", session.Phase)
+}
+
+func (server *Server) sessionLogger(session *Session) *slog.Logger {
+	server.SessionsMutex.RLock()
+	defer server.SessionsMutex.RUnlock()
+
+	return session.loggerLocked()
+}
+
+func (server *Server) sessionIdLogger(sessionId string) *slog.Logger {
+	server.SessionsMutex.RLock()
+	defer server.SessionsMutex.RUnlock()
+
+	if session, ok := server.Sessions[sessionId]; ok {
+		return session.loggerLocked()
+	}
+
+	return slog.With("session_id", sessionId)
+}
+
+// Warm pool sessions change their id on adoption, workers outliving that look the id up for every line
+func (server *Server) sessionServiceLogger(session *Session, service string) *slog.Logger {
+	server.SessionsMutex.RLock()
+	defer server.SessionsMutex.RUnlock()
+
+	return session.loggerLocked().With("service", service)
+}
+
+func fatal(message string, args ...any) {
+	slog.Error(message, args...)
+	os.Exit(1)
+}
+
+func withAccessLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		recordingWriter := &statusRecordingWriter{ResponseWriter: writer}
+
+		next.ServeHTTP(recordingWriter, request)
+
+		remoteHost, _, _ := net.SplitHostPort(request.RemoteAddr)
+		if remoteHost == "" {
+			remoteHost = request.RemoteAddr
+		}
+
+		attributes := []any{
+			"method", request.Method,
+			"path", request.URL.Path,
+			"remote", remoteHost,
+			"status", recordingWriter.status(),
+			"bytes", recordingWriter.Bytes,
+			"duration", time.Since(started).Truncate(time.Millisecond),
+		}
+		if recordingWriter.SessionId != "" {
+			attributes = append(attributes, "session_id", recordingWriter.SessionId)
+		}
+		if recordingWriter.Upstream != "" {
+			attributes = append(attributes, "upstream", recordingWriter.Upstream)
+		}
+
+		slog.Info("HTTP request", attributes...)
+	})
+}
+
+func setAccessLogSession(writer http.ResponseWriter, sessionId string) {
+	if recordingWriter, ok := writer.(*statusRecordingWriter); ok {
+		recordingWriter.SessionId = sessionId
+	}
+}
+
+type statusRecordingWriter struct {
+	http.ResponseWriter
+	StatusCode int
+	Bytes      int64
+	SessionId  string
+	Upstream   string
+}
+
+func (writer *statusRecordingWriter) status() int {
+	if writer.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return writer.StatusCode
+}
+
+func (writer *statusRecordingWriter) WriteHeader(statusCode int) {
+	if writer.StatusCode == 0 && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		writer.StatusCode = statusCode
+	}
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (writer *statusRecordingWriter) Write(data []byte) (int, error) {
+	if writer.StatusCode == 0 {
+		writer.StatusCode = http.StatusOK
+	}
+
+	written, err := writer.ResponseWriter.Write(data)
+	writer.Bytes += int64(written)
+	return written, err
+}
+
+func (writer *statusRecordingWriter) Flush() {
+	_ = http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+func (writer *statusRecordingWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSessionLoggersCarrySessionState(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buffer, nil)))
+	defer slog.SetDefault(previousLogger)
+
+	server := newTestServer(t)
+	session := newTestReadySession(server, "logged-session")
+
+	tests := []struct {
+		name     string
+		log      func()
+		expected map[string]string
+	}{
+		{
+			name:     "session logger",
+			log:      func() { server.sessionLogger(session).Info("line") },
+			expected: map[string]string{"session_id": session.Id, "phase": string(SessionPhaseReady)},
+		},
+		{
+			name:     "service logger",
+			log:      func() { server.sessionServiceLogger(session, "client").Info("line") },
+			expected: map[string]string{"session_id": session.Id, "phase": string(SessionPhaseReady), "service": "client"},
+		},
+		{
+			name:     "proxy logger",
+			log:      func() { session.Proxy.Logger().Info("line") },
+			expected: map[string]string{"session_id": session.Id, "phase": string(SessionPhaseReady)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer.Reset()
+			test.log()
+
+			record := map[string]any{}
+			if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", buffer.String(), err)
+			}
+			for key, value := range test.expected {
+				if record[key] != value {
+					t.Fatalf("%s = %v, expected %q in %s", key, record[key], value, buffer.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SessionLogLine struct {
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Service  string    `json:"service"`
+	Stream   string    `json:"stream"`
+	Line     string    `json:"line"`
+}
+
+type sessionLogRing struct {
+	Lines []SessionLogLine
+	Start int
+}
+
+type SessionLogs struct {
+	Rings        map[string]*sessionLogRing
+	LastSequence uint64
+	Subscribers  map[chan SessionLogLine]bool
+	Closed       bool
+	Mutex        sync.Mutex
+}
+
+const sessionLogSubscriberBuffer = 256
+
+const maxLogLineLength = 16 * 1024
+
+const (
+	logStreamRetryMinimum = 1 * time.Second
+	logStreamRetryMaximum = 30 * time.Second
+)
+
+func newSessionLogs() *SessionLogs {
+	return &SessionLogs{
+		Rings:       map[string]*sessionLogRing{},
+		Subscribers: map[chan SessionLogLine]bool{},
+	}
+}
+
+func (logs *SessionLogs) append(service string, stream string, line string, capacity int) {
+	if logs == nil || capacity <= 0 {
+		return
+	}
+
+	logs.Mutex.Lock()
+	defer logs.Mutex.Unlock()
+
+	if logs.Closed {
+		return
+	}
+
+	logs.LastSequence++
+	logLine := SessionLogLine{Sequence: logs.LastSequence, Time: time.Now().UTC(), Service: service, Stream: stream, Line: line}
+
+	ring, ok := logs.Rings[service]
+	if !ok {
+		ring = &sessionLogRing{}
+		logs.Rings[service] = ring
+	}
+
+	if len(ring.Lines) < capacity {
+		ring.Lines = append(ring.Lines, logLine)
+	} else {
+		ring.Lines[ring.Start] = logLine
+		ring.Start = (ring.Start + 1) % len(ring.Lines)
+	}
+
+	for subscriber := range logs.Subscribers {
+		select {
+		case subscriber <- logLine:
+		default:
+			// Slow readers are dropped, their EventSource reconnects and resumes from Last-Event-ID
+			close(subscriber)
+			delete(logs.Subscribers, subscriber)
+		}
+	}
+}
+
+func (logs *SessionLogs) snapshotLocked(service string, afterSequence uint64) []SessionLogLine {
+	lines := []SessionLogLine{}
+	for ringService, ring := range logs.Rings {
+		if service != "" && ringService != service {
+			continue
+		}
+
+		for index := range ring.Lines {
+			logLine := ring.Lines[(ring.Start+index)%len(ring.Lines)]
+			if logLine.Sequence > afterSequence {
+				lines = append(lines, logLine)
+			}
+		}
+	}
+
+	slices.SortFunc(lines, func(left SessionLogLine, right SessionLogLine) int {
+		return cmp.Compare(left.Sequence, right.Sequence)
+	})
+
+	return lines
+}
+
+func (logs *SessionLogs) snapshot(service string) []SessionLogLine {
+	logs.Mutex.Lock()
+	defer logs.Mutex.Unlock()
+
+	return logs.snapshotLocked(service, 0)
+}
+
+func (logs *SessionLogs) subscribe(service string, afterSequence uint64) ([]SessionLogLine, chan SessionLogLine, func()) {
+	logs.Mutex.Lock()
+	defer logs.Mutex.Unlock()
+
+	backlog := logs.snapshotLocked(service, afterSequence)
+
+	subscriber := make(chan SessionLogLine, sessionLogSubscriberBuffer)
+	if logs.Closed {
+		close(subscriber)
+		return backlog, subscriber, func() {}
+	}
+	logs.Subscribers[subscriber] = true
+
+	unsubscribe := func() {
+		logs.Mutex.Lock()
+		defer logs.Mutex.Unlock()
+
+		if logs.Subscribers[subscriber] {
+			delete(logs.Subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return backlog, subscriber, unsubscribe
+}
+
+func (logs *SessionLogs) close() {
+	logs.Mutex.Lock()
+	defer logs.Mutex.Unlock()
+
+	logs.Closed = true
+	for subscriber := range logs.Subscribers {
+		close(subscriber)
+		delete(logs.Subscribers, subscriber)
+	}
+}
+
+func (server *Server) handleSessionLogs(writer http.ResponseWriter, request *http.Request, session *Session) {
+	service := request.URL.Query().Get("service")
+	if service != "" && !slices.Contains(sessionServices, service) {
+		http.Error(writer, "Unknown service", http.StatusBadRequest)
+		return
+	}
+
+	format := request.URL.Query().Get("format")
+	if format == "" && strings.Contains(request.Header.Get("Accept"), "text/html") {
+		server.writeSessionLogsHtml(writer, session)
+		return
+	}
+
+	lines := session.Logs.snapshot(service)
+	fileName := "session-" + session.SanitizedId + "-logs"
+
+	switch format {
+	case "ndjson":
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+		writer.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`.ndjson"`)
+
+		encoder := json.NewEncoder(writer)
+		for _, line := range lines {
+			_ = encoder.Encode(line)
+		}
+	case "", "text":
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if format != "" {
+			writer.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`.txt"`)
+		}
+
+		for _, line := range lines {
+			fmt.Fprintf(writer, "%s [%s %s] %s\n", line.Time.Format(time.RFC3339Nano), line.Service, line.Stream, line.Line)
+		}
+	default:
+		http.Error(writer, "Unknown format, expected text or ndjson", http.StatusBadRequest)
+	}
+}
+
+func (server *Server) handleSessionLogEvents(writer http.ResponseWriter, request *http.Request, session *Session) {
+	service := request.URL.Query().Get("service")
+	if service != "" && !slices.Contains(sessionServices, service) {
+		http.Error(writer, "Unknown service", http.StatusBadRequest)
+		return
+	}
+
+	afterSequence, _ := strconv.ParseUint(request.Header.Get("Last-Event-ID"), 10, 64)
+	responseController := http.NewResponseController(writer)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+
+	writeEvent := func(line SessionLogLine) bool {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return false
+		}
+
+		if _, err := fmt.Fprintf(writer, "id: %d\nevent: log\ndata: %s\n\n", line.Sequence, data); err != nil {
+			return false
+		}
+
+		return true
+	}
+
+	backlog, updates, unsubscribe := session.Logs.subscribe(service, afterSequence)
+	defer unsubscribe()
+
+	for _, line := range backlog {
+		if !writeEvent(line) {
+			return
+		}
+	}
+	if responseController.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-server.Context.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(writer, ": heartbeat\n\n"); err != nil || responseController.Flush() != nil {
+				return
+			}
+		case line, open := <-updates:
+			if !open {
+				return
+			}
+
+			if service != "" && line.Service != service {
+				continue
+			}
+
+			if !writeEvent(line) || responseController.Flush() != nil {
+				server.sessionLogger(session).Debug("Log stream closed")
+				return
+			}
+		}
+	}
+}
+
+func (server *Server) writeSessionLogsHtml(writer http.ResponseWriter, session *Session) {
+	serviceOptionsHtml := strings.Builder{}
+	for _, service := range sessionServices {
+		fmt.Fprintf(&serviceOptionsHtml, "\n          <option value=\"%s\">%s</option>", service, service)
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+
+	page := fmt.Sprintf(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <meta name="viewport" content="width=device-width, initial-scale=1, viewport-fit=cover"/>
+  <title>Session logs</title>
+  <style>
+    :root {
+      --bg-color: #0f0b1e;
+      --card-bg: #1a162d;
+      --card-border: #4c2f7a;
+      --text-main: #e9d5ff;
+      --text-muted: #a39eb5;
+      --accent: #d946ef; /* Neon Purple/Pink */
+      --pill-bg: #281f3f;
+    }
+
+    html, body {
+      height: 100%%;
+      margin: 0;
+      background-color: var(--bg-color);
+      color: var(--text-main);
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+      -webkit-font-smoothing: antialiased;
+    }
+
+    .root {
+      height: 100%%;
+      display: flex;
+      flex-direction: column;
+      gap: 12px;
+      padding: 12px;
+      box-sizing: border-box;
+    }
+
+    .toolbar {
+      flex: none;
+      display: flex;
+      align-items: center;
+      gap: 12px;
+      padding: 8px 8px 8px 16px;
+      border: 1px solid var(--card-border);
+      border-radius: 12px;
+      background: var(--card-bg);
+      font-size: 14px;
+    }
+
+    .toolbar .title {
+      flex: 1;
+      color: var(--text-muted);
+    }
+
+    .toolbar select, .toolbar a, .toolbar label {
+      padding: 6px 14px;
+      border-radius: 999px;
+      border: 1px solid var(--accent);
+      background: transparent;
+      color: var(--text-main);
+      font: inherit;
+      text-decoration: none;
+    }
+
+    .toolbar label {
+      border-color: var(--card-border);
+    }
+
+    .toolbar select option {
+      background: var(--card-bg);
+    }
+
+    .toolbar a:hover {
+      background: var(--accent);
+      color: #fff;
+    }
+
+    pre {
+      flex: 1;
+      margin: 0;
+      padding: 12px 16px;
+      overflow: auto;
+      border: 1px solid var(--card-border);
+      border-radius: 12px;
+      background: #0b0816;
+      font: 12px/1.5 ui-monospace, SFMono-Regular, Menlo, monospace;
+      white-space: pre-wrap;
+      word-break: break-all;
+    }
+
+    .service {
+      color: var(--accent);
+    }
+
+    .time {
+      color: #6b7280;
+    }
+
+    .stderr {
+      color: #fca5a5;
+    }
+  </style>
+</head>
+<body>
+  <div class="root">
+    <div class="toolbar">
+      <span class="title" id="statusText">Session %s logs</span>
+      <select id="serviceSelect">
+          <option value="">All services</option>%s
+      </select>
+      <label><input type="checkbox" id="followCheckbox" checked/> Follow</label>
+      <a href="logs?format=text">Download text</a>
+      <a href="logs?format=ndjson">Download NDJSON</a>
+    </div>
+    <pre id="logOutput"></pre>
+  </div>
+
+<script>
+(function() {
+  const outputElement = document.getElementById("logOutput");
+  const serviceSelect = document.getElementById("serviceSelect");
+  const followCheckbox = document.getElementById("followCheckbox");
+  const statusTextElement = document.getElementById("statusText");
+  const maxRenderedLines = 5000;
+  let events = null;
+
+  function appendLine(line) {
+    const row = document.createElement("div");
+    const time = document.createElement("span");
+    const service = document.createElement("span");
+
+    time.className = "time";
+    time.textContent = new Date(line.time).toLocaleTimeString() + " ";
+    service.className = "service";
+    service.textContent = "[" + line.service + "] ";
+
+    if (line.stream === "stderr") row.className = "stderr";
+    row.append(time, service, document.createTextNode(line.line));
+    outputElement.append(row);
+
+    while (outputElement.childElementCount > maxRenderedLines) {
+      outputElement.firstElementChild.remove();
+    }
+
+    if (followCheckbox.checked) {
+      outputElement.scrollTop = outputElement.scrollHeight;
+    }
+  }
+
+  function connect() {
+    if (events) events.close();
+    outputElement.textContent = "";
+
+    const service = serviceSelect.value;
+    events = new EventSource("logs/events" + (service ? "?service=" + encodeURIComponent(service) : ""));
+    events.addEventListener("log", function(event) {
+      appendLine(JSON.parse(event.data));
+    });
+    events.onopen = function() {
+      statusTextElement.style.color = "";
+    };
+    events.onerror = function() {
+      statusTextElement.style.color = "#fca5a5";
+    };
+  }
+
+  serviceSelect.addEventListener("change", connect);
+  connect();
+})();
+</script>
+</body>
+</html>`, html.EscapeString(session.SanitizedId[:min(12, len(session.SanitizedId))]), serviceOptionsHtml.String())
+
+	_, _ = writer.Write([]byte(page))
+}
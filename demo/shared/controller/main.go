@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
@@ -9,23 +10,29 @@ import (
 	"fmt"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 type LogPrefixWriter struct {
-	Prefix string
-	Buffer strings.Builder
-	Mutex  sync.Mutex
+	Stream        string
+	MaxLineLength int
+	HandleLine    func(stream string, line string)
+	Pending       []byte
+	Closed        bool
+	Mutex         sync.Mutex
 }
 
 type Session struct {
@@ -37,16 +44,90 @@ type Session struct {
 	CreatedUtc    time.Time
 	ExpiresUtc    time.Time
 	DeleteTimer   *time.Timer
-	Ready         bool
+	Phase         SessionPhase
+	PhaseHistory  []SessionPhaseTransition
+	Watcher       *SessionWatcher
+	Logs          *SessionLogs
+	Containers    map[string]*SessionContainerState
+	Proxy         *SessionProxy
+	LastError     string
+	FailureReason string
+	ClientAddress string
+	Pooled        bool
+	LastPolledUtc time.Time
+
+	Healthy          bool
+	HealthCheckedUtc time.Time
+	HealthError      string
+	DegradedReason   string
+
+	LastActivityUtc time.Time
+	ActiveRequests  int
+
+	AbandonedUtc         time.Time
+	ExpiresBeforeAbandon time.Time
+
+	Context context.Context
+	Cancel  context.CancelFunc
 }
 
 type Server struct {
 	ListenAddress string
 	SessionTtl    time.Duration
 	RedirectLogs  bool
-
-	Sessions      map[string]*Session
-	SessionsMutex sync.RWMutex
+	LogLines      int
+	Runtime       SessionRuntime
+	Store         *SessionStore
+
+	ReconcileInterval time.Duration
+	ReconcilerStats   ReconcilerStats
+
+	MaxSessions          int
+	MaxSessionsPerClient int
+	QueueAbandonTimeout  time.Duration
+	FailedSessionGrace   time.Duration
+	TrustForwardedFor    bool
+	Queue                []string
+	WarmPoolSize         int
+
+	HealthProbeInterval    time.Duration
+	HealthProbeMaxInterval time.Duration
+	CrashLoopRestarts      int
+	CrashLoopWindow        time.Duration
+
+	SessionExtension   time.Duration
+	MaxSessionLifetime time.Duration
+	IdleTimeout        time.Duration
+	AbandonGrace       time.Duration
+
+	ShutdownTeardown     bool
+	ShutdownDrainTimeout time.Duration
+	ShutdownTimeout      time.Duration
+	ShutdownParallelism  int
+
+	ShuttingDown     atomic.Bool
+	ActiveLogStreams atomic.Int64
+	Context          context.Context
+	Cancel           context.CancelFunc
+
+	AdminToken     string
+	MetricsToken   string
+	RecentFailures []ProvisioningFailure
+	DemoDomain     string
+	SessionKey     []byte
+
+	ProxyDialTimeout           time.Duration
+	ProxyMaxIdleConns          int
+	ProxyMaxIdleConnsPerHost   int
+	ProxyMaxConnsPerHost       int
+	ProxyIdleConnTimeout       time.Duration
+	ProxyResponseHeaderTimeout time.Duration
+	ProxyDialer                *net.Dialer
+	ProxyTransport             *http.Transport
+
+	Sessions         map[string]*Session
+	StoppingProjects map[string]bool
+	SessionsMutex    sync.RWMutex
 }
 
 type clientGameStatus struct {
@@ -57,21 +138,136 @@ type clientGameStatus struct {
 }
 
 func main() {
+	if err := setupLogging(); err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+
+	runtimeName := getEnvString("SESSION_RUNTIME", "compose")
+	runtime, err := newSessionRuntime(runtimeName)
+	if err != nil {
+		fatal("Failed to create session runtime", "error", err)
+	}
+
+	serverContext, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
 	server := &Server{
 		SessionTtl:    time.Duration(getEnvInt("SESSION_TTL_SECONDS", 7200)) * time.Second,
 		ListenAddress: getEnvString("LISTEN_ADDRESS", "0.0.0.0:80"),
 		RedirectLogs:  getEnvBool("REDIRECT_LOGS", false),
-		Sessions:      map[string]*Session{},
+		LogLines:      getEnvInt("SESSION_LOG_LINES", 1000),
+		Runtime:       runtime,
+		Store:         &SessionStore{Path: getEnvString("SESSION_STORE_PATH", "state/sessions.json")},
+
+		ReconcileInterval: time.Duration(getEnvInt("RECONCILE_INTERVAL_SECONDS", 60)) * time.Second,
+
+		MaxSessions:          getEnvInt("MAX_SESSIONS", 8),
+		MaxSessionsPerClient: getEnvInt("MAX_SESSIONS_PER_CLIENT", 2),
+		QueueAbandonTimeout:  time.Duration(getEnvInt("QUEUE_ABANDON_SECONDS", 60)) * time.Second,
+		FailedSessionGrace:   time.Duration(getEnvInt("FAILED_SESSION_GRACE_SECONDS", 300)) * time.Second,
+		TrustForwardedFor:    getEnvBool("TRUST_FORWARDED_FOR", false),
+		WarmPoolSize:         getEnvInt("WARM_POOL_SIZE", 0),
+
+		HealthProbeInterval:    time.Duration(getEnvInt("HEALTH_PROBE_INTERVAL_SECONDS", 5)) * time.Second,
+		HealthProbeMaxInterval: time.Duration(getEnvInt("HEALTH_PROBE_MAX_INTERVAL_SECONDS", 60)) * time.Second,
+		CrashLoopRestarts:      getEnvInt("CRASH_LOOP_RESTARTS", 3),
+		CrashLoopWindow:        time.Duration(getEnvInt("CRASH_LOOP_WINDOW_SECONDS", 300)) * time.Second,
+
+		SessionExtension:   time.Duration(getEnvInt("SESSION_EXTEND_SECONDS", 1800)) * time.Second,
+		MaxSessionLifetime: time.Duration(getEnvInt("MAX_SESSION_LIFETIME_SECONDS", 14400)) * time.Second,
+		IdleTimeout:        time.Duration(getEnvInt("IDLE_TIMEOUT_SECONDS", 0)) * time.Second,
+		AbandonGrace:       time.Duration(getEnvInt("ABANDON_GRACE_SECONDS", 120)) * time.Second,
+
+		ShutdownTeardown:     getEnvBool("SHUTDOWN_TEARDOWN_SESSIONS", false),
+		ShutdownDrainTimeout: time.Duration(getEnvInt("SHUTDOWN_DRAIN_TIMEOUT_SECONDS", 10)) * time.Second,
+		ShutdownTimeout:      time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 60)) * time.Second,
+		ShutdownParallelism:  getEnvInt("SHUTDOWN_PARALLELISM", 4),
+
+		AdminToken:   getEnvString("ADMIN_TOKEN", ""),
+		MetricsToken: getEnvString("METRICS_TOKEN", ""),
+		DemoDomain:   normalizeDemoDomain(getEnvString("DEMO_DOMAIN", "")),
+
+		Context: serverContext,
+		Cancel:  cancelServer,
+
+		ProxyDialTimeout:           time.Duration(getEnvInt("PROXY_DIAL_TIMEOUT_SECONDS", 5)) * time.Second,
+		ProxyMaxIdleConns:          getEnvInt("PROXY_MAX_IDLE_CONNS", 256),
+		ProxyMaxIdleConnsPerHost:   getEnvInt("PROXY_MAX_IDLE_CONNS_PER_HOST", 32),
+		ProxyMaxConnsPerHost:       getEnvInt("PROXY_MAX_CONNS_PER_HOST", 0),
+		ProxyIdleConnTimeout:       time.Duration(getEnvInt("PROXY_IDLE_CONN_TIMEOUT_SECONDS", 90)) * time.Second,
+		ProxyResponseHeaderTimeout: time.Duration(getEnvInt("PROXY_RESPONSE_HEADER_TIMEOUT_SECONDS", 0)) * time.Second,
+
+		Sessions:         map[string]*Session{},
+		StoppingProjects: map[string]bool{},
+	}
+
+	server.setupProxyTransport()
+
+	sessionKey, configured, err := loadSessionKey(getEnvString("SESSION_COOKIE_KEY", ""))
+	if err != nil {
+		fatal("Failed to create session cookie key", "error", err)
+	}
+	if !configured {
+		slog.Warn("SESSION_COOKIE_KEY is not set, using a random key so visitors lose access to restored sessions after a restart")
 	}
+	server.SessionKey = sessionKey
 
-	if output, err := dockerCommand("network", "prune", "-f").Output(); err != nil {
-		log.Fatalf("Failed to prune docker networks: %v. Output: %s", err, string(output))
+	if err := server.Runtime.Prepare(); err != nil {
+		fatal("Failed to prepare session runtime", "error", err)
 	}
 
-	if err := server.ensureSessionImagesAvailable(); err != nil {
-		log.Fatalf("Failed to ensure session images are available: %v", err)
+	if err := server.restoreSessions(); err != nil {
+		fatal("Failed to restore sessions", "error", err)
+	}
+
+	go server.runReconciler()
+	go server.runIdleSweeper()
+	server.refillWarmPool()
+
+	httpServer := &http.Server{
+		Addr:              server.ListenAddress,
+		Handler:           server.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
 	}
+	httpServer.RegisterOnShutdown(server.Cancel)
+
+	slog.Info("Listening",
+		"address", "http://"+server.ListenAddress,
+		"runtime", runtimeName,
+		"store", server.Store.Path,
+		"session_ttl", server.SessionTtl,
+		"session_extension", server.SessionExtension,
+		"max_session_lifetime", server.MaxSessionLifetime,
+		"idle_timeout", server.IdleTimeout,
+		"reconcile_interval", server.ReconcileInterval,
+		"max_sessions", server.MaxSessions,
+		"max_sessions_per_client", server.MaxSessionsPerClient,
+		"warm_pool_size", server.WarmPoolSize,
+		"redirect_logs", server.RedirectLogs,
+		"session_log_lines", server.LogLines,
+		"admin_api", server.AdminToken != "",
+		"shutdown_teardown", server.ShutdownTeardown,
+		"shutdown_drain_timeout", server.ShutdownDrainTimeout,
+		"shutdown_timeout", server.ShutdownTimeout,
+		"shutdown_parallelism", server.ShutdownParallelism,
+	)
+
+	signalContext, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "error", err)
+		}
+	}()
+
+	<-signalContext.Done()
+	stopSignals()
+
+	server.shutdown(httpServer)
+}
 
+func (server *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		if request.URL.Path != "/" {
@@ -83,20 +279,12 @@ func main() {
 	})
 	mux.HandleFunc("/status/", server.handleStatus)
 	mux.HandleFunc("/session/", server.handleSession)
+	mux.HandleFunc("/admin/api/sessions", server.handleAdminApi)
+	mux.HandleFunc("/admin/api/sessions/", server.handleAdminApi)
+	mux.HandleFunc("/admin/", server.handleAdminPage)
+	mux.HandleFunc("/metrics", server.handleMetrics)
 
-	httpServer := &http.Server{
-		Addr:              server.ListenAddress,
-		Handler:           withBasicLogging(mux),
-		ReadHeaderTimeout: 10 * time.Second,
-	}
-
-	log.Printf("Listening on http://%s", server.ListenAddress)
-	log.Printf("Session TTL: %s", server.SessionTtl)
-	log.Printf("Redirect logs: %v", server.RedirectLogs)
-
-	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("HTTP server failed: %v", err)
-	}
+	return withAccessLogging(server.withSessionHostRouting(mux))
 }
 
 func (server *Server) handleNewSession(writer http.ResponseWriter, request *http.Request) {
@@ -106,78 +294,250 @@ func (server *Server) handleNewSession(writer http.ResponseWriter, request *http
 		return
 	}
 
-	session := &Session{Id: sessionId}
+	if server.ShuttingDown.Load() {
+		server.writeShuttingDownHtml(writer)
+		return
+	}
 
-	session.SanitizedId = sanitizeForDockerName(sessionId)
-	session.CreatedUtc = time.Now().UTC()
+	session := newSession(sessionId)
 	session.ExpiresUtc = session.CreatedUtc.Add(server.SessionTtl)
+	session.ClientAddress = server.clientAddress(request)
+	session.LastPolledUtc = session.CreatedUtc
 
 	server.SessionsMutex.Lock()
+	if server.MaxSessionsPerClient > 0 && server.countClientSessionsLocked(session.ClientAddress) >= server.MaxSessionsPerClient {
+		server.SessionsMutex.Unlock()
+		slog.Warn("Client reached the session limit", "client_address", session.ClientAddress, "limit", server.MaxSessionsPerClient)
+		server.writeTooManySessionsHtml(writer)
+		return
+	}
+
+	adoptedFromPool := false
+	if len(server.Queue) == 0 {
+		if pooledSession := server.adoptPooledSessionLocked(session); pooledSession != nil {
+			session = pooledSession
+			adoptedFromPool = true
+		}
+	}
+
+	if !adoptedFromPool {
+		if server.MaxSessions > 0 && server.countActiveSessionsLocked() >= server.MaxSessions {
+			session.setPhaseLocked(SessionPhaseQueued)
+			server.Queue = append(server.Queue, session.Id)
+		} else {
+			session.setPhaseLocked(SessionPhaseComposing)
+		}
+	}
+
 	server.Sessions[session.Id] = session
+	// promoteQueuedSessions may provision the session as soon as the lock is released
+	queued := session.Phase == SessionPhaseQueued
+	queuePosition := len(server.Queue)
+	ownerCookieExpiresUtc := server.ownerCookieExpiresUtc(session)
 	server.SessionsMutex.Unlock()
+	controllerMetrics.SessionsCreated.inc("false")
+	server.setSessionAccessCookie(writer, request, session.Id, SessionAccessOwner, ownerCookieExpiresUtc)
 	http.Redirect(writer, request, "/session/"+session.Id+"/", http.StatusTemporaryRedirect)
 
-	go func() {
-		startedSuccessfully := false
-		defer func() {
-			if startedSuccessfully {
-				return
-			}
+	if adoptedFromPool {
+		server.armDeleteTimer(session)
+		server.persistSessions()
+		server.sessionLogger(session).Info("Started instantly from the warm pool")
+		go server.refillWarmPool()
+		return
+	}
+
+	if queued {
+		server.sessionLogger(session).Info("Queued", "queue_position", queuePosition)
+		return
+	}
+
+	server.persistSessions()
+	go server.provisionSession(session)
+}
+
+func (server *Server) provisionSession(session *Session) {
+	startedSuccessfully := false
+	defer func() {
+		if startedSuccessfully {
+			return
+		}
 
+		if session.Pooled {
+			server.SessionsMutex.Lock()
+			server.discardSessionLocked(session)
+			server.SessionsMutex.Unlock()
+			time.AfterFunc(warmPoolRetryDelay, server.refillWarmPool)
+		} else {
 			server.SessionsMutex.Lock()
-			delete(server.Sessions, session.Id)
+			session.ExpiresUtc = time.Now().UTC().Add(server.FailedSessionGrace)
 			server.SessionsMutex.Unlock()
-		}()
+			server.armDeleteTimer(session)
+		}
 
-		log.Printf("Creating new session %s (TTL: %s)", session.Id, server.SessionTtl)
+		server.persistSessions()
+		server.promoteQueuedSessions()
+	}()
+
+	if session.Pooled {
+		server.sessionLogger(session).Info("Creating new warm pool session")
+	} else {
+		server.sessionLogger(session).Info("Creating new session", "ttl", server.SessionTtl)
+	}
+
+	if err := server.startSessionContainers(session); err != nil {
+		server.failSession(session, err)
+		return
+	}
+
+	server.SessionsMutex.Lock()
+	session.Healthy = true
+	session.HealthCheckedUtc = time.Now().UTC()
+	session.LastActivityUtc = session.HealthCheckedUtc
+	session.setPhaseLocked(SessionPhaseReady)
+	server.buildSessionProxyLocked(session)
+	pooled := session.Pooled
+	if !pooled {
+		session.ExpiresUtc = time.Now().UTC().Add(server.SessionTtl)
+	}
+	server.SessionsMutex.Unlock()
+
+	startedSuccessfully = true
+	go server.runHealthMonitor(session)
+
+	if pooled {
+		server.persistSessions()
+		server.sessionLogger(session).Info("Warm pool session is ready")
+		server.promoteQueuedSessions()
+		return
+	}
+
+	server.armDeleteTimer(session)
+	server.persistSessions()
+
+	server.sessionLogger(session).Info("Session created successfully")
+}
+
+func (server *Server) armDeleteTimer(session *Session) {
+	server.SessionsMutex.Lock()
+	defer server.SessionsMutex.Unlock()
+
+	if session.DeleteTimer != nil {
+		session.DeleteTimer.Stop()
+	}
+
+	session.DeleteTimer = time.AfterFunc(time.Until(session.ExpiresUtc), func() {
+		server.SessionsMutex.RLock()
+		expiredReason := "ttl"
+		if !session.AbandonedUtc.IsZero() {
+			expiredReason = "abandoned"
+		}
+		failed := session.Phase == SessionPhaseFailed
+		abandonedInUse := expiredReason == "abandoned" && session.inUseLocked()
+		server.SessionsMutex.RUnlock()
 
-		if err := server.startSessionContainers(session); err != nil {
-			log.Printf("Failed to start session containers for session %s: %v", session.Id, err)
+		// Someone opened the session during the grace period without reclaiming it, keep it running
+		if abandonedInUse {
+			server.reclaimAbandonedSession(session)
 			return
 		}
 
-		deleteTimer := time.AfterFunc(server.SessionTtl, func() {
-			err := server.deleteSession(session.Id)
-			if err != nil {
-				log.Printf("Failed to delete session on timer %s: %v", session.Id, err)
-			}
-		})
-		server.SessionsMutex.Lock()
-		session.DeleteTimer = deleteTimer
-		session.Ready = true
-		server.SessionsMutex.Unlock()
+		if !failed {
+			controllerMetrics.SessionsExpired.inc(expiredReason)
+		}
 
-		startedSuccessfully = true
-		log.Printf("Session %s created successfully", session.Id)
-	}()
+		err := server.deleteSession(session.Id)
+		if err != nil {
+			server.sessionLogger(session).Error("Failed to delete expired session", "error", err)
+		}
+	})
+}
+
+type sessionStatusResponse struct {
+	Exists        bool                     `json:"exists"`
+	Ready         bool                     `json:"ready"`
+	Queued        bool                     `json:"queued"`
+	QueuePosition int                      `json:"queuePosition"`
+	SessionId     string                   `json:"sessionId"`
+	SecondsLeft   int64                    `json:"secondsLeft"`
+	Phase         SessionPhase             `json:"phase"`
+	Phases        []SessionPhaseTransition `json:"phases"`
+	LastError     string                   `json:"lastError"`
+	Healthy       bool                     `json:"healthy"`
+	CanExtend     bool                     `json:"canExtend"`
+	HealthChecked *time.Time               `json:"healthCheckedUtc,omitempty"`
+
+	Degraded       bool                             `json:"degraded"`
+	DegradedReason string                           `json:"degradedReason,omitempty"`
+	Containers     map[string]SessionContainerState `json:"containers,omitempty"`
+	Access         string                           `json:"access,omitempty"`
+
+	WarmPoolSize     int `json:"warmPoolSize"`
+	WarmPoolReady    int `json:"warmPoolReady"`
+	WarmPoolStarting int `json:"warmPoolStarting"`
 }
 
 func (server *Server) handleStatus(writer http.ResponseWriter, request *http.Request) {
 	sessionId := strings.TrimPrefix(request.URL.Path, "/status/")
 	sessionId = strings.Trim(sessionId, "/")
-	if sessionId == "" {
+
+	if eventsSessionId, isEvents := strings.CutSuffix(sessionId, "/events"); isEvents {
+		setAccessLogSession(writer, eventsSessionId)
+		if server.isSessionAccessDenied(request, eventsSessionId) {
+			http.Error(writer, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		server.handleStatusEvents(writer, request, eventsSessionId)
+		return
+	}
+
+	if sessionId == "" || strings.Contains(sessionId, "/") {
 		http.NotFound(writer, request)
 		return
 	}
 
-	server.SessionsMutex.RLock()
+	setAccessLogSession(writer, sessionId)
+	if server.isSessionAccessDenied(request, sessionId) {
+		http.Error(writer, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	response := server.sessionStatus(sessionId)
+	response.Access = server.requestSessionAccess(request, sessionId).String()
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func (server *Server) sessionStatus(sessionId string) sessionStatusResponse {
+	queuePosition := 0
+	var containers map[string]SessionContainerState
+
+	server.SessionsMutex.Lock()
 	session, ok := server.Sessions[sessionId]
+	ok = ok && !session.Pooled
 	if ok {
+		session.LastPolledUtc = time.Now().UTC()
+		queuePosition = server.queuePositionLocked(session.Id)
+		containers = cloneContainerStatesLocked(session.Containers)
+
 		sessionSnapshot := *session
+		sessionSnapshot.PhaseHistory = slices.Clone(session.PhaseHistory)
 		session = &sessionSnapshot
 	}
-	server.SessionsMutex.RUnlock()
+	warmPoolReady, warmPoolStarting := server.countPooledSessionsLocked()
+	server.SessionsMutex.Unlock()
 
-	type statusResponse struct {
-		Exists      bool   `json:"exists"`
-		Ready       bool   `json:"ready"`
-		SessionId   string `json:"sessionId"`
-		SecondsLeft int64  `json:"secondsLeft"`
-	}
+	response := sessionStatusResponse{
+		Exists:        ok,
+		SessionId:     sessionId,
+		Queued:        queuePosition > 0,
+		QueuePosition: queuePosition,
 
-	response := statusResponse{
-		Exists:    ok,
-		SessionId: sessionId,
+		WarmPoolSize:     server.WarmPoolSize,
+		WarmPoolReady:    warmPoolReady,
+		WarmPoolStarting: warmPoolStarting,
 	}
 
 	if ok {
@@ -186,13 +546,23 @@ func (server *Server) handleStatus(writer http.ResponseWriter, request *http.Req
 			secondsLeft = 0
 		}
 		response.SecondsLeft = int64(secondsLeft)
+		response.Phase = session.Phase
+		response.Phases = session.PhaseHistory
+		response.LastError = session.FailureReason
+		response.Healthy = session.Healthy
+		response.CanExtend = session.Phase == SessionPhaseReady && session.ExpiresUtc.Before(server.maxExpiresUtc(session))
+		if !session.HealthCheckedUtc.IsZero() {
+			response.HealthChecked = &session.HealthCheckedUtc
+		}
+		response.Degraded = session.DegradedReason != ""
+		response.DegradedReason = session.DegradedReason
+		response.Containers = containers
 
 		readyValue := server.isSessionReady(session)
 		response.Ready = readyValue
 	}
 
-	writer.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(writer).Encode(response)
+	return response
 }
 
 func (server *Server) handleSession(writer http.ResponseWriter, request *http.Request) {
@@ -220,10 +590,18 @@ func (server *Server) handleSession(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
+	server.serveSession(writer, request, sessionId, restPath, false)
+}
+
+func (server *Server) serveSession(writer http.ResponseWriter, request *http.Request, sessionId string, restPath string, hostRouted bool) {
+	setAccessLogSession(writer, sessionId)
+
 	server.SessionsMutex.RLock()
-	session, ok := server.Sessions[sessionId]
+	liveSession, ok := server.Sessions[sessionId]
+	ok = ok && !liveSession.Pooled
+	var session *Session
 	if ok {
-		sessionSnapshot := *session
+		sessionSnapshot := *liveSession
 		session = &sessionSnapshot
 	}
 	server.SessionsMutex.RUnlock()
@@ -233,81 +611,190 @@ func (server *Server) handleSession(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	targetUrl := &url.URL{
-		Scheme: "http",
-		Host:   session.DashboardHost,
+	if shareToken := request.URL.Query().Get(sessionShareParameter); shareToken != "" && request.Method == http.MethodGet {
+		server.redeemShareToken(writer, request, sessionId, shareToken)
+		return
+	}
+
+	access := server.requestSessionAccess(request, sessionId)
+	if access == SessionAccessNone {
+		server.sessionIdLogger(sessionId).Warn("Rejected request without session access", "path", restPath)
+		server.writeSessionForbiddenHtml(writer, "This session belongs to someone else, ask its owner for a share link")
+		return
 	}
 
-	reverseProxy := httputil.NewSingleHostReverseProxy(targetUrl)
+	controlPath := restPath == "/retry" || restPath == "/extend" || restPath == "/end" || restPath == "/abandon" || restPath == "/share" || ((restPath == "" || restPath == "/") && request.Method == http.MethodDelete)
+	if controlPath && access < SessionAccessFull {
+		http.Error(writer, "Forbidden, this share link is view only", http.StatusForbidden)
+		return
+	}
 
-	originalDirector := reverseProxy.Director
-	reverseProxy.Director = func(proxyRequest *http.Request) {
-		originalDirector(proxyRequest)
+	switch {
+	case restPath == "/share" && request.Method == http.MethodPost:
+		if access < SessionAccessOwner {
+			http.Error(writer, "Forbidden, only the session owner can share it", http.StatusForbidden)
+			return
+		}
+		server.handleShare(writer, request, session)
+		return
+	case restPath == "/retry" && request.Method == http.MethodPost:
+		server.handleRetry(writer, request, session)
+		return
+	case restPath == "/extend" && request.Method == http.MethodPost:
+		server.handleExtend(writer, liveSession)
+		return
+	case (restPath == "" || restPath == "/") && request.Method == http.MethodDelete:
+		server.endSession(liveSession)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	case restPath == "/end" && request.Method == http.MethodPost:
+		server.endSession(liveSession)
+		server.writeSessionEndedHtml(writer)
+		return
+	case restPath == "/abandon" && request.Method == http.MethodPost:
+		// Share holders closing their tab must not start the owner's grace period
+		if access < SessionAccessOwner {
+			http.Error(writer, "Forbidden, only the session owner can abandon it", http.StatusForbidden)
+			return
+		}
+		server.abandonSession(liveSession)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	case restPath == "/logs" && request.Method == http.MethodGet:
+		server.handleSessionLogs(writer, request, liveSession)
+		return
+	case restPath == "/logs/events" && request.Method == http.MethodGet:
+		server.handleSessionLogEvents(writer, request, liveSession)
+		return
+	case restPath == "/status" && request.Method == http.MethodGet:
+		// Open dashboards poll this, so a tab that is still open reclaims a session another one abandoned
+		server.reclaimAbandonedSession(liveSession)
+		response := server.sessionStatus(sessionId)
+		response.Access = access.String()
+
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(response)
+		return
+	}
 
-		proxyRequest.URL.Path = restPath
-		proxyRequest.URL.RawPath = restPath
-		proxyRequest.Host = targetUrl.Host
-		proxyRequest.Header.Set("X-Path-Prefix", "session/"+sessionId)
+	// Controller pages live on the main domain, a session host only serves the ready dashboard
+	if hostRouted && !server.isSessionReady(session) {
+		http.Redirect(writer, request, server.demoDomainUrl(request, "/session/"+sessionId+"/"), http.StatusFound)
+		return
 	}
 
-	reverseProxy.ErrorHandler = func(proxyWriter http.ResponseWriter, proxyRequest *http.Request, proxyError error) {
-		log.Printf("Session %s upstream not reachable yet: %v", sessionId, proxyError)
-		server.writeSessionStartingHtml(proxyWriter, sessionId)
+	if session.Phase == SessionPhaseFailed {
+		server.writeSessionFailedHtml(writer, session)
+		return
 	}
 
 	if !server.isSessionReady(session) {
-		reverseProxy.ErrorHandler(writer, request, fmt.Errorf("session %s not ready", sessionId))
-	} else {
-		reverseProxy.ServeHTTP(writer, request)
+		server.sessionIdLogger(sessionId).Warn("Upstream not reachable yet", "upstream", proxyUpstreamName(restPath), "error", fmt.Errorf("session %s not ready", sessionId))
+		server.writeSessionStartingHtml(writer, sessionId)
+		return
 	}
-}
 
-func (server *Server) isSessionReady(session *Session) bool {
-	if !session.Ready {
-		return false
+	if !hostRouted && server.DemoDomain != "" && restPath == "/" && request.Method == http.MethodGet {
+		http.Redirect(writer, request, server.sessionHostUrl(request, session), http.StatusFound)
+		return
 	}
 
-	httpClient := &http.Client{
-		Timeout: time.Second,
+	if access < SessionAccessFull && request.Method != http.MethodGet && request.Method != http.MethodHead && request.Method != http.MethodOptions {
+		http.Error(writer, "Forbidden, this share link is view only", http.StatusForbidden)
+		return
 	}
 
-	probe := func(host string, path string) bool {
-		if strings.TrimSpace(host) == "" {
-			log.Printf("Host for probing is still empty, skipping probe")
-			return false
-		}
+	sessionProxy := session.Proxy
+	if sessionProxy == nil {
+		sessionProxy = server.sessionProxy(liveSession)
+	}
 
-		request, err := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
-		if err != nil {
-			log.Printf("Failed to create probe request for host %s: %v", host, err)
-			return false
-		}
+	server.trackSessionActivity(liveSession, true)
+	defer server.trackSessionActivity(liveSession, false)
+	server.reclaimAbandonedSession(liveSession)
 
-		response, err := httpClient.Do(request)
-		if err != nil {
-			log.Printf("Probe request to host %s failed: %v", host, err)
-			return false
-		}
+	upstream := proxyUpstreamName(restPath)
+	recordingWriter, ok := writer.(*statusRecordingWriter)
+	if !ok {
+		recordingWriter = &statusRecordingWriter{ResponseWriter: writer}
+	}
+	recordingWriter.Upstream = upstream
+	started := time.Now()
+
+	proxyRequest := new(http.Request)
+	*proxyRequest = *request
+	proxyRequest.URL = new(url.URL)
+	*proxyRequest.URL = *request.URL
+	proxyRequest.URL.Path = restPath
+	proxyRequest.URL.RawPath = restPath
+	proxyRequest.Header = request.Header.Clone()
+	stripSessionAccessCookies(proxyRequest.Header)
+	if hostRouted {
+		proxyRequest.Header.Set("X-Path-Prefix", "")
+	} else {
+		proxyRequest.Header.Set("X-Path-Prefix", "session/"+sessionId+"/")
+	}
+	// The dashboard starts noVNC in view only mode and the proxy filters what spectators send over websockets
+	proxyRequest.Header.Set("X-View-Only", strconv.FormatBool(access < SessionAccessFull))
+
+	sessionProxy.ServeHTTP(recordingWriter, proxyRequest)
 
-		_ = response.Body.Close()
+	controllerMetrics.ProxyRequests.inc(upstream, strconv.Itoa(recordingWriter.status()))
+	controllerMetrics.ProxyRequestDuration.observe(time.Since(started), upstream)
+}
 
-		log.Printf("Probed %s: %d", host, response.StatusCode)
+func (server *Server) handleRetry(writer http.ResponseWriter, request *http.Request, session *Session) {
+	if session.Phase != SessionPhaseFailed {
+		http.Redirect(writer, request, "/session/"+session.Id+"/", http.StatusSeeOther)
+		return
+	}
 
-		return response.StatusCode == http.StatusOK
+	logger := server.sessionLogger(session)
+	if err := server.deleteSession(session.Id); err != nil {
+		logger.Error("Failed to delete failed session before retry", "error", err)
 	}
 
-	return probe(session.DashboardHost, "/") && probe(session.VoidHost, "/") && probe(session.ClientHost, "/api/health")
+	logger.Info("Retrying with a fresh session")
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
 }
 
 func (server *Server) writeSessionStartingHtml(writer http.ResponseWriter, sessionId string) {
-	server.writeLiveHtml(writer, http.StatusOK, "Starting session", "Your session container is starting", "/session/"+sessionId+"/")
+	server.SessionsMutex.RLock()
+	queuePosition := server.queuePositionLocked(sessionId)
+	server.SessionsMutex.RUnlock()
+
+	if queuePosition > 0 {
+		server.writeLiveHtml(writer, http.StatusOK, "Waiting in queue", fmt.Sprintf("All demo slots are busy, you are #%d in the queue", queuePosition), "/session/"+sessionId+"/", "")
+		return
+	}
+
+	server.writeLiveHtml(writer, http.StatusOK, "Starting session", "Your session container is starting", "/session/"+sessionId+"/", "")
+}
+
+func (server *Server) writeTooManySessionsHtml(writer http.ResponseWriter) {
+	server.writeLiveHtml(writer, http.StatusTooManyRequests, "Too many sessions", "You already have the maximum number of demo sessions running, close one or wait for it to expire", "", "")
+}
+
+func (server *Server) writeSessionFailedHtml(writer http.ResponseWriter, session *Session) {
+	actionsHtml := `<form method="post" action="/session/` + html.EscapeString(session.Id) + `/retry"><button class="button" type="submit">Start a new session</button></form>` +
+		`<a class="button" href="/session/` + html.EscapeString(session.Id) + `/logs" target="_blank" rel="noopener">View logs</a>`
+	server.writeLiveHtml(writer, http.StatusServiceUnavailable, "Session failed to start", session.FailureReason, "", actionsHtml)
+}
+
+func (server *Server) writeSessionEndedHtml(writer http.ResponseWriter) {
+	actionsHtml := `<a class="button" href="/">Start a new session</a>`
+	server.writeLiveHtml(writer, http.StatusOK, "Session ended", "Your demo session was stopped and its resources were released", "", actionsHtml)
+}
+
+func (server *Server) writeShuttingDownHtml(writer http.ResponseWriter) {
+	server.writeLiveHtml(writer, http.StatusServiceUnavailable, "Demo is restarting", "The demo controller is shutting down, please come back in a minute", "", "")
 }
 
 func (server *Server) writeSessionExpiredHtml(writer http.ResponseWriter) {
-	server.writeLiveHtml(writer, http.StatusNotFound, "Session expired", "This session no longer exists", "/")
+	server.writeLiveHtml(writer, http.StatusNotFound, "Session expired", "This session no longer exists", "/", "")
 }
 
-func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int, title string, subtitle string, retryPath string) {
+func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int, title string, subtitle string, retryPath string, actionsHtml string) {
 	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
 	writer.WriteHeader(statusCode)
 
@@ -330,6 +817,21 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
 	titleHtml := html.EscapeString(title)
 	subtitleHtml := html.EscapeString(subtitle)
 
+	statusText := "Checking status…"
+	footerHtml := `
+      <p class="footer-text">
+        System is auto-checking session connectivity. You will be redirected automatically once the live session is reachable.
+      </p>`
+	if retryPath == "" {
+		statusText = "Try again later"
+		footerHtml = ""
+	}
+
+	phaseListHtml := strings.Builder{}
+	for _, step := range sessionPhaseSteps {
+		fmt.Fprintf(&phaseListHtml, "\n        <li data-phase=\"%s\"><span class=\"check\"></span><span class=\"label\">%s</span><span class=\"elapsed\"></span></li>", step.Phase, html.EscapeString(step.Label))
+	}
+
 	page := fmt.Sprintf(`<!doctype html>
 <html lang="en">
 <head>
@@ -431,6 +933,89 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
       100%% { opacity: 1; transform: scale(1); box-shadow: 0 0 0 0 rgba(0,0,0,0); }
     }
 
+    .phases {
+      list-style: none;
+      margin: 24px 0 0 0;
+      padding: 0;
+      display: flex;
+      flex-direction: column;
+      gap: 10px;
+      font-size: 14px;
+    }
+
+    .phases[hidden] {
+      display: none;
+    }
+
+    .phases li {
+      display: flex;
+      align-items: center;
+      gap: 12px;
+      color: #6b7280;
+    }
+
+    .phases .check {
+      width: 14px;
+      height: 14px;
+      border-radius: 50%%;
+      border: 2px solid var(--card-border);
+      box-sizing: border-box;
+      flex: none;
+    }
+
+    .phases .elapsed {
+      margin-left: auto;
+      font-variant-numeric: tabular-nums;
+    }
+
+    .phases li.done {
+      color: var(--text-muted);
+    }
+
+    .phases li.done .check {
+      background: #a7f3d0;
+      border-color: #a7f3d0;
+    }
+
+    .phases li.current {
+      color: var(--text-main);
+    }
+
+    .phases li.current .check {
+      border-color: var(--accent);
+      box-shadow: 0 0 10px var(--accent-glow);
+      animation: pulse 2s infinite cubic-bezier(0.4, 0, 0.6, 1);
+    }
+
+    .phases li.failed {
+      color: #fca5a5;
+    }
+
+    .phases li.failed .check {
+      background: #f87171;
+      border-color: #f87171;
+    }
+
+    .button {
+      appearance: none;
+      padding: 10px 20px;
+      border-radius: 999px;
+      border: 1px solid var(--accent);
+      background: var(--accent);
+      color: #fff;
+      font: inherit;
+      font-weight: 600;
+      font-size: 14px;
+      cursor: pointer;
+      text-decoration: none;
+      box-shadow: 0 0 20px -5px var(--accent-glow);
+      transition: filter 0.2s;
+    }
+
+    .button:hover {
+      filter: brightness(1.1);
+    }
+
     .footer-text {
       margin-top: 24px;
       font-size: 13px;
@@ -456,13 +1041,15 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
       <div class="row">
         <div class="pill">
           <span class="dot"></span>
-          <span id="statusText">Checking status…</span>
+          <span id="statusText">%s</span>
         </div>
+        %s
       </div>
 
-      <p class="footer-text">
-        System is auto-checking session connectivity. You will be redirected automatically once the live session is reachable.
-      </p>
+      <ul class="phases" id="phaseList" hidden>%s
+      </ul>
+
+%s
     </div>
   </div>
 
@@ -473,6 +1060,7 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
   const sessionId = %s;
   
   const statusTextElement = document.getElementById("statusText");
+  const phaseListElement = document.getElementById("phaseList");
 
   if (!statusTextElement) {
     console.error("Critical: Status text element missing.");
@@ -480,11 +1068,80 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
   }
 
   // Direct redirection if no session ID implies immediate retry/login needed
-  if (!sessionId && retryPath) {
-    location.replace(retryPath);
+  if (!sessionId) {
+    if (retryPath) {
+      location.replace(retryPath);
+    }
     return;
   }
 
+  function formatElapsed(milliseconds) {
+    const seconds = Math.max(0, Math.round(milliseconds / 1000));
+    return seconds < 60 ? seconds + "s" : Math.floor(seconds / 60) + "m " + (seconds %% 60) + "s";
+  }
+
+  function renderPhases(status) {
+    if (!phaseListElement || !status.phase) return;
+
+    const phases = status.phases || [];
+    const startedByPhase = {};
+    phases.forEach(function(transition, index) {
+      const next = phases[index + 1];
+      startedByPhase[transition.phase] = {
+        started: Date.parse(transition.startedUtc),
+        ended: next ? Date.parse(next.startedUtc) : Date.now()
+      };
+    });
+
+    const items = Array.from(phaseListElement.children);
+    const failedPhase = status.phase === "failed" && phases.length > 1 ? phases[phases.length - 2].phase : null;
+    const currentPhase = failedPhase || status.phase;
+    const currentIndex = items.findIndex(function(item) { return item.dataset.phase === currentPhase; });
+
+    items.forEach(function(item, index) {
+      const timing = startedByPhase[item.dataset.phase];
+      item.className = index < currentIndex ? "done" : index === currentIndex ? (failedPhase ? "failed" : "current") : "";
+      item.querySelector(".elapsed").textContent = timing && item.dataset.phase !== "ready" ? formatElapsed(timing.ended - timing.started) : "";
+    });
+
+    phaseListElement.hidden = false;
+  }
+
+  // Returns true once the page is navigating away and no more updates are needed
+  function applyStatus(status) {
+    renderPhases(status);
+
+    // Session no longer exists -> go home
+    if (!status.exists) {
+      location.replace("/");
+      return true;
+    }
+
+    // Session failed -> show the failure page with the retry button
+    if (status.phase === "failed") {
+      location.replace(retryPath);
+      return true;
+    }
+
+    // Session is ready -> go to application
+    if (status.ready) {
+      statusTextElement.textContent = "Session Ready! Redirecting...";
+      statusTextElement.style.color = "#a7f3d0"; // Little visual green hint
+      location.replace(retryPath);
+      return true;
+    }
+
+    if (status.queued) {
+      statusTextElement.textContent = "Waiting in queue (position " + status.queuePosition + ")...";
+    } else if (status.phase === "ready") {
+      statusTextElement.textContent = "Session is not responding, reconnecting...";
+    } else {
+      statusTextElement.textContent = "Starting environment...";
+    }
+
+    return false;
+  }
+
   async function tick() {
     try {
       // Added timestamp to prevent aggressive browser caching issues
@@ -494,38 +1151,35 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
         headers: { 'Cache-Control': 'no-cache' }
       });
 
-      if (!response.ok) return;
-
-      const status = await response.json();
-
-      // Session no longer exists -> go home
-      if (!status.exists) {
-        location.replace("/");
-        return;
-      }
-
-      // Session is ready -> go to application
-      if (status.ready) {
-        statusTextElement.textContent = "Session Ready! Redirecting...";
-        statusTextElement.style.color = "#a7f3d0"; // Little visual green hint
-        location.replace(retryPath);
-        return;
-      }
-
-      statusTextElement.textContent = "Starting environment...";
-      
+      if (response.ok && applyStatus(await response.json())) return;
     } catch(error) {
       console.warn("Status check failed, retrying...", error);
-    } finally {
-      setTimeout(tick, 1000);
     }
+
+    setTimeout(tick, 1000);
+  }
+
+  // Prefer pushed updates, fall back to polling if the stream is unavailable
+  if (!window.EventSource) {
+    tick();
+    return;
   }
 
-  tick();
+  const events = new EventSource("/status/" + encodeURIComponent(sessionId) + "/events");
+  events.addEventListener("status", function(event) {
+    if (applyStatus(JSON.parse(event.data))) {
+      events.close();
+    }
+  });
+  events.onerror = function() {
+    console.warn("Status stream failed, falling back to polling...");
+    events.close();
+    tick();
+  };
 })();
 </script>
 </body>
-</html>`, titleHtml, titleHtml, subtitleHtml, retryPathJs, sessionIdJs)
+</html>`, titleHtml, titleHtml, subtitleHtml, html.EscapeString(statusText), actionsHtml, phaseListHtml.String(), footerHtml, retryPathJs, sessionIdJs)
 
 	_, _ = writer.Write([]byte(page))
 }
@@ -533,8 +1187,18 @@ func (server *Server) writeLiveHtml(writer http.ResponseWriter, statusCode int,
 func (server *Server) deleteSession(sessionId string) error {
 	server.SessionsMutex.Lock()
 	session, ok := server.Sessions[sessionId]
+	var sessionProxy *SessionProxy
+	var phase SessionPhase
+	var logger *slog.Logger
 	if ok {
-		delete(server.Sessions, sessionId)
+		sessionProxy = session.Proxy
+		phase = session.Phase
+		logger = session.loggerLocked()
+		server.discardSessionLocked(session)
+		controllerMetrics.SessionsDeleted.inc()
+		if phase != SessionPhaseQueued && phase != SessionPhaseFailed {
+			server.StoppingProjects[session.SanitizedId] = true
+		}
 	}
 	server.SessionsMutex.Unlock()
 
@@ -542,41 +1206,56 @@ func (server *Server) deleteSession(sessionId string) error {
 		return nil
 	}
 
-	log.Printf("Deleting session %s", session.Id)
+	if closedTunnels := sessionProxy.close(); closedTunnels > 0 {
+		logger.Info("Closed open WebSockets", "count", closedTunnels)
+	}
+
+	if phase == SessionPhaseQueued || phase == SessionPhaseFailed {
+		logger.Info("Removed session")
+		server.promoteQueuedSessions()
+		return nil
+	}
+
+	server.persistSessions()
+
+	logger.Info("Deleting session")
 
 	err := server.stopSession(session)
+	server.promoteQueuedSessions()
+	server.refillWarmPool()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Session %s cleanup completed", session.Id)
+	logger.Info("Session cleanup completed")
 	return nil
 }
 
-func (server *Server) stopSession(session *Session) error {
-	stopOutput, stopErr := dockerCommand("compose", "--project-name", session.SanitizedId, "--file", "session.yml", "down", "--remove-orphans", "--volumes").CombinedOutput()
-	if stopErr != nil {
-		return fmt.Errorf("docker compose down failed: %v: %s", stopErr, string(stopOutput))
-	}
-	return nil
+// Every path that drops a session from the map goes through here so its log streams, event watcher and subscribers stop
+func (server *Server) discardSessionLocked(session *Session) {
+	delete(server.Sessions, session.Id)
+	server.removeFromQueueLocked(session.Id)
+	session.Cancel()
+	session.Watcher.notify()
+	session.Logs.close()
 }
 
-func (server *Server) ensureSessionImagesAvailable() error {
-	build := dockerCommand("compose", "--file", "session.yml", "build")
+func (server *Server) stopSession(session *Session) error {
+	server.SessionsMutex.Lock()
+	server.StoppingProjects[session.SanitizedId] = true
+	server.SessionsMutex.Unlock()
 
-	build.Stdout = os.Stdout
-	build.Stderr = os.Stderr
-	build.Stdin = os.Stdin
+	defer func() {
+		server.SessionsMutex.Lock()
+		delete(server.StoppingProjects, session.SanitizedId)
+		server.SessionsMutex.Unlock()
+	}()
 
-	err := build.Run()
-	if err != nil {
-		return fmt.Errorf("docker compose build failed: %v", err)
-	}
-	return nil
+	return server.Runtime.Teardown(session)
 }
 
 func (server *Server) startSessionContainers(session *Session) (returnedError error) {
-	log.Printf("Session %s: Starting containers", session.Id)
+	server.sessionLogger(session).Info("Starting containers")
 
 	defer func() {
 		if returnedError != nil {
@@ -584,104 +1263,59 @@ func (server *Server) startSessionContainers(session *Session) (returnedError er
 		}
 	}()
 
-	startOutputBytes, startError := dockerCommand("compose", "--project-name", session.SanitizedId, "--file", "session.yml", "up", "--build", "--detach").CombinedOutput()
-	if startError != nil {
-		log.Printf("Session %s: Failed to start containers with docker compose: %v, output: %s", session.Id, startError, string(startOutputBytes))
-		return fmt.Errorf("failed to start containers with docker compose: %v: %s", startError, string(startOutputBytes))
-	}
-
-	log.Printf("Session %s: Containers started with docker compose", session.Id)
-
-	containerIdBytes, listContainersError := dockerCommand("compose", "--project-name", session.SanitizedId, "--file", "session.yml", "ps", "-q").Output()
-	if listContainersError != nil {
-		log.Printf("Session %s: Failed to list compose containers: %v", session.Id, listContainersError)
-		return fmt.Errorf("failed to list compose containers: %v", listContainersError)
+	if err := server.Runtime.Provision(session); err != nil {
+		server.sessionLogger(session).Error("Failed to provision containers", "error", err)
+		return err
 	}
 
-	containerIds := strings.Fields(string(containerIdBytes))
-	if len(containerIds) == 0 {
-		log.Printf("Session %s: No containers returned by docker compose ps -q", session.Id)
-		return fmt.Errorf("no containers returned by docker compose ps -q")
-	}
+	server.sessionLogger(session).Info("Containers started")
 
-	inspectArguments := []string{
-		"inspect",
-		"--format",
-		"{{.Name}} {{index .Config.Labels \"com.docker.compose.service\"}}",
+	hosts, err := server.Runtime.ResolveHosts(session)
+	if err != nil {
+		return err
 	}
-	inspectArguments = append(inspectArguments, containerIds...)
 
-	inspectOutputBytes, inspectError := dockerCommand(inspectArguments...).CombinedOutput()
-	if inspectError != nil {
-		return fmt.Errorf("failed to inspect compose containers: %v: %s", inspectError, string(inspectOutputBytes))
+	for _, service := range sessionServices {
+		server.streamContainerLogs(session, service)
 	}
+	go server.watchContainerEvents(session)
 
-	dashboardContainerName := ""
-	clientContainerName := ""
-	voidContainerName := ""
-
-	inspectLines := strings.SplitSeq(strings.TrimSpace(string(inspectOutputBytes)), "\n")
-	for inspectLine := range inspectLines {
-		inspectFields := strings.Fields(inspectLine)
-		if len(inspectFields) < 2 {
-			continue
-		}
-
-		containerName := strings.TrimPrefix(strings.TrimSpace(inspectFields[0]), "/")
-		composeServiceName := strings.TrimSpace(inspectFields[1])
-
-		switch composeServiceName {
-		case "dashboard":
-			dashboardContainerName = containerName
-		case "client":
-			clientContainerName = containerName
-		case "void":
-			voidContainerName = containerName
-		}
-
-		if server.RedirectLogs {
-			if containerName == "" {
-				continue
-			}
-
-			server.streamContainerLogs(containerName)
-		}
-	}
+	server.SessionsMutex.Lock()
+	session.DashboardHost = hosts.DashboardHost
+	session.ClientHost = hosts.ClientHost
+	session.VoidHost = hosts.VoidHost
+	server.SessionsMutex.Unlock()
 
-	if strings.TrimSpace(dashboardContainerName) == "" {
-		return fmt.Errorf("dashboard container name not found")
+	setPhase := func(phase SessionPhase) {
+		server.setSessionPhase(session, phase)
 	}
-
-	if strings.TrimSpace(clientContainerName) == "" {
-		return fmt.Errorf("client container name not found")
+	clientLogger := func() *slog.Logger {
+		return server.sessionServiceLogger(session, "client")
 	}
-
-	if strings.TrimSpace(voidContainerName) == "" {
-		return fmt.Errorf("void container name not found")
+	if err := startAndJoinPortableMinecraftClient(hosts.ClientHost, createMinecraftUsername(session.SanitizedId), setPhase, clientLogger); err != nil {
+		return err
 	}
 
-	server.SessionsMutex.Lock()
-	session.DashboardHost = dashboardContainerName
-	session.ClientHost = clientContainerName
-	session.VoidHost = voidContainerName
-	server.SessionsMutex.Unlock()
-
-	if err := startAndJoinPortableMinecraftClient(clientContainerName, createMinecraftUsername(session.SanitizedId)); err != nil {
+	setPhase(SessionPhaseProbing)
+	if err := waitForSessionServices(hosts); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func startAndJoinPortableMinecraftClient(clientHost string, minecraftUsername string) error {
+func startAndJoinPortableMinecraftClient(clientHost string, minecraftUsername string, setPhase func(SessionPhase), logger func() *slog.Logger) error {
 	clientApiUrl := &url.URL{
 		Scheme: "http",
-		Host:   net.JoinHostPort(clientHost, "80"),
+		Host:   hostWithDefaultPort(clientHost, "80"),
 	}
-	if err := waitForPortableMinecraftClient(clientApiUrl); err != nil {
+	setPhase(SessionPhaseWaitingClientApi)
+	if err := waitForPortableMinecraftClient(clientApiUrl, logger); err != nil {
 		return err
 	}
 
+	setPhase(SessionPhaseLaunchingGame)
+
 	clientApiUrl.Path = "/api/game/start/neoforge"
 	startRequest := map[string]any{
 		"arguments": []string{"--username", minecraftUsername, "--jvm-arg=-Djava.awt.headless=false"},
@@ -731,8 +1365,9 @@ func startAndJoinPortableMinecraftClient(clientHost string, minecraftUsername st
 		time.Sleep(250 * time.Millisecond)
 	}
 
-	log.Printf("Portable Minecraft client launch confirmed from %s", clientHost)
+	logger().Info("Portable Minecraft client launch confirmed", "client_host", clientHost)
 
+	setPhase(SessionPhaseJoining)
 	clientApiUrl.Path = "/api/game/connect"
 	httpClient.Timeout = 5 * time.Minute
 	responseStatusCode, responseBody, err = requestPortableMinecraftClient(httpClient, http.MethodPost, clientApiUrl, map[string]any{"host": "void", "port": 25565})
@@ -743,11 +1378,31 @@ func startAndJoinPortableMinecraftClient(clientHost string, minecraftUsername st
 		return fmt.Errorf("portable Minecraft client connection returned %d: %s", responseStatusCode, responseBody)
 	}
 
-	log.Printf("Portable Minecraft client joined the server from %s", clientHost)
+	logger().Info("Portable Minecraft client joined the server", "client_host", clientHost)
+	return nil
+}
+
+func stopPortableMinecraftClient(clientHost string, logger func() *slog.Logger) error {
+	clientApiUrl := &url.URL{
+		Scheme: "http",
+		Host:   hostWithDefaultPort(clientHost, "80"),
+		Path:   "/api/game/stop",
+	}
+
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	responseStatusCode, responseBody, err := requestPortableMinecraftClient(httpClient, http.MethodPost, clientApiUrl, nil)
+	if err != nil {
+		return fmt.Errorf("portable Minecraft client stop request failed: %w", err)
+	}
+	if responseStatusCode != http.StatusOK && responseStatusCode != http.StatusAccepted {
+		return fmt.Errorf("portable Minecraft client stop returned %d: %s", responseStatusCode, responseBody)
+	}
+
+	logger().Info("Portable Minecraft client stopped", "client_host", clientHost)
 	return nil
 }
 
-func waitForPortableMinecraftClient(clientApiUrl *url.URL) error {
+func waitForPortableMinecraftClient(clientApiUrl *url.URL, logger func() *slog.Logger) error {
 	clientApiUrl.Path = "/api/health"
 	httpClient := &http.Client{Timeout: 2 * time.Second}
 	deadline := time.Now().Add(2 * time.Minute)
@@ -756,7 +1411,7 @@ func waitForPortableMinecraftClient(clientApiUrl *url.URL) error {
 	for time.Now().Before(deadline) {
 		responseStatusCode, responseBody, err := requestPortableMinecraftClient(httpClient, http.MethodGet, clientApiUrl, nil)
 		if err == nil && responseStatusCode == http.StatusOK {
-			log.Printf("Portable Minecraft client API is ready at %s", clientApiUrl.Host)
+			logger().Info("Portable Minecraft client API is ready", "client_host", clientApiUrl.Host)
 			return nil
 		}
 
@@ -807,6 +1462,19 @@ func requestPortableMinecraftClient(httpClient *http.Client, method string, clie
 	return response.StatusCode, strings.TrimSpace(string(responseBody)), nil
 }
 
+func newSession(sessionId string) *Session {
+	sessionContext, cancel := context.WithCancel(context.Background())
+
+	return &Session{
+		Id:          sessionId,
+		SanitizedId: sanitizeForDockerName(sessionId),
+		CreatedUtc:  time.Now().UTC(),
+		Logs:        newSessionLogs(),
+		Context:     sessionContext,
+		Cancel:      cancel,
+	}
+}
+
 func createSessionId() (string, error) {
 	randomBytes := make([]byte, 32)
 	if _, err := rand.Read(randomBytes); err != nil {
@@ -861,39 +1529,82 @@ func createMinecraftUsername(sanitizedSessionId string) string {
 	return "void" + suffix
 }
 
-func dockerCommand(arguments ...string) *exec.Cmd {
-	command := exec.Command("docker", arguments...)
-	command.Env = os.Environ()
-	return command
-}
-
-func (server *Server) streamContainerLogs(containerName string) {
-	if !server.RedirectLogs {
-		return
+func hostWithDefaultPort(host string, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
 	}
 
+	return net.JoinHostPort(host, defaultPort)
+}
+
+func (server *Server) streamContainerLogs(session *Session, service string) {
 	go func() {
-		log.Printf("Starting log stream for container: %s", containerName)
+		server.ActiveLogStreams.Add(1)
+		defer server.ActiveLogStreams.Add(-1)
 
-		writer := &LogPrefixWriter{Prefix: "[" + containerName + "] "}
+		logger := func() *slog.Logger {
+			return server.sessionServiceLogger(session, service)
+		}
+		logger().Debug("Starting log stream")
+
+		handleLine := func(stream string, line string) {
+			session.Logs.append(service, stream, line, server.LogLines)
+			if service == "void" {
+				if event, ok := parseVoidProxyExit(line); ok {
+					server.handleContainerEvent(session, event)
+				}
+			}
+			if server.RedirectLogs {
+				logger().Info(line, "stream", stream)
+			}
+		}
+		stdoutWriter := &LogPrefixWriter{Stream: "stdout", MaxLineLength: maxLogLineLength, HandleLine: handleLine}
+		stderrWriter := &LogPrefixWriter{Stream: "stderr", MaxLineLength: maxLogLineLength, HandleLine: handleLine}
+
+		defer stdoutWriter.Close()
+		defer stderrWriter.Close()
+
+		// Containers restart under their compose restart policy, keep reattaching until the session context is cancelled
+		var sinceUtc time.Time
+		retryDelay := logStreamRetryMinimum
+		for {
+			attachedUtc := time.Now().UTC()
+			err := server.Runtime.StreamLogs(session.Context, session, service, sinceUtc, stdoutWriter, stderrWriter)
+			stdoutWriter.Flush()
+			stderrWriter.Flush()
+
+			if session.Context.Err() != nil {
+				logger().Debug("Log stream stopped")
+				return
+			}
 
-		logsCommand := dockerCommand("logs", "--follow", "--tail=0", containerName)
+			if errors.Is(err, errContainerGone) {
+				logger().Debug("Container is gone, log stream ended")
+				err = nil
+			}
+			if err != nil {
+				logger().Debug("Log stream interrupted", "error", err, "retry_in", retryDelay)
+			} else {
+				sinceUtc = time.Now().UTC()
+			}
+			if sinceUtc.IsZero() {
+				sinceUtc = attachedUtc
+			}
 
-		var stderrBuffer bytes.Buffer
-		logsCommand.Stdout = writer
-		logsCommand.Stderr = io.MultiWriter(writer, &stderrBuffer)
+			if time.Since(attachedUtc) > logStreamRetryMaximum {
+				retryDelay = logStreamRetryMinimum
+			}
 
-		err := logsCommand.Run()
-		if err == nil {
-			return
-		}
+			select {
+			case <-session.Context.Done():
+				logger().Debug("Log stream stopped")
+				return
+			case <-time.After(retryDelay):
+			}
 
-		stderrText := stderrBuffer.String()
-		if strings.Contains(stderrText, "No such container") || strings.Contains(stderrText, "is not running") {
-			return
+			retryDelay = min(retryDelay*2, logStreamRetryMaximum)
+			controllerMetrics.LogStreamReconnects.inc(service)
 		}
-
-		log.Printf("Log streaming ended for %s: %v", containerName, err)
 	}()
 }
 
@@ -901,41 +1612,80 @@ func (writer *LogPrefixWriter) Write(data []byte) (int, error) {
 	writer.Mutex.Lock()
 	defer writer.Mutex.Unlock()
 
-	_, _ = writer.Buffer.Write(data)
-	content := writer.Buffer.String()
-
-	lines := strings.Split(content, "\n")
-
-	if !strings.HasSuffix(content, "\n") {
-		writer.Buffer.Reset()
-		_, _ = writer.Buffer.WriteString(lines[len(lines)-1])
-		lines = lines[:len(lines)-1]
-	} else {
-		writer.Buffer.Reset()
+	if writer.Closed {
+		return 0, io.ErrClosedPipe
 	}
 
-	for _, line := range lines {
-		if line != "" {
-			log.Printf("%s%s", writer.Prefix, line)
+	remaining := data
+	for len(remaining) > 0 {
+		newlineIndex := bytes.IndexByte(remaining, '\n')
+		if newlineIndex == -1 {
+			writer.Pending = append(writer.Pending, remaining...)
+			writer.emitOverlongLocked()
+			break
 		}
+
+		writer.Pending = append(writer.Pending, remaining[:newlineIndex]...)
+		remaining = remaining[newlineIndex+1:]
+
+		writer.emitOverlongLocked()
+		writer.emitLocked(writer.Pending)
+		writer.Pending = writer.Pending[:0]
 	}
 
 	return len(data), nil
 }
 
-func withBasicLogging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		started := time.Now()
+func (writer *LogPrefixWriter) Flush() {
+	writer.Mutex.Lock()
+	defer writer.Mutex.Unlock()
+
+	writer.emitLocked(writer.Pending)
+	writer.Pending = writer.Pending[:0]
+}
+
+func (writer *LogPrefixWriter) Close() error {
+	writer.Flush()
 
-		next.ServeHTTP(writer, request)
+	writer.Mutex.Lock()
+	writer.Closed = true
+	writer.Pending = nil
+	writer.Mutex.Unlock()
+
+	return nil
+}
 
-		remoteHost, _, _ := net.SplitHostPort(request.RemoteAddr)
-		if remoteHost == "" {
-			remoteHost = request.RemoteAddr
+func (writer *LogPrefixWriter) emitOverlongLocked() {
+	if writer.MaxLineLength <= 0 {
+		return
+	}
+
+	for len(writer.Pending) > writer.MaxLineLength {
+		// Cut on a rune boundary so multi-byte characters are never split between two lines
+		cutIndex := writer.MaxLineLength
+		for cutIndex > 0 && !utf8.RuneStart(writer.Pending[cutIndex]) {
+			cutIndex--
+		}
+		if cutIndex == 0 {
+			// The limit is shorter than the first rune, emit that rune whole once all of its bytes arrived
+			if !utf8.FullRune(writer.Pending) {
+				return
+			}
+			_, cutIndex = utf8.DecodeRune(writer.Pending)
 		}
 
-		log.Printf("%s %s %s %s", request.Method, request.URL.Path, remoteHost, time.Since(started).Truncate(time.Millisecond))
-	})
+		writer.emitLocked(writer.Pending[:cutIndex])
+		writer.Pending = append(writer.Pending[:0], writer.Pending[cutIndex:]...)
+	}
+}
+
+func (writer *LogPrefixWriter) emitLocked(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(line) == 0 {
+		return
+	}
+
+	writer.HandleLine(writer.Stream, strings.ToValidUTF8(string(line), "\uFFFD"))
 }
 
 func getEnvString(name string, defaultValue string) string {
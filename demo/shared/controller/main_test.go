@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func newTestServer(tb testing.TB) *Server {
+	tb.Helper()
+
+	serverContext, cancelServer := context.WithCancel(context.Background())
+	server := &Server{
+		SessionTtl: time.Hour,
+		LogLines:   100,
+		Runtime:    &FakeRuntime{},
+		Store:      &SessionStore{Path: filepath.Join(tb.TempDir(), "sessions.json")},
+
+		FailedSessionGrace: time.Minute,
+		CrashLoopRestarts:  3,
+		CrashLoopWindow:    5 * time.Minute,
+
+		SessionExtension:   30 * time.Minute,
+		MaxSessionLifetime: 4 * time.Hour,
+		AbandonGrace:       2 * time.Minute,
+
+		Context:    serverContext,
+		Cancel:     cancelServer,
+		SessionKey: []byte("test session key"),
+
+		ProxyDialTimeout:         5 * time.Second,
+		ProxyMaxIdleConns:        256,
+		ProxyMaxIdleConnsPerHost: 32,
+		ProxyIdleConnTimeout:     90 * time.Second,
+
+		Sessions:         map[string]*Session{},
+		StoppingProjects: map[string]bool{},
+	}
+	server.setupProxyTransport()
+
+	tb.Cleanup(func() {
+		server.SessionsMutex.RLock()
+		sessionIds := slices.Collect(maps.Keys(server.Sessions))
+		server.SessionsMutex.RUnlock()
+
+		for _, sessionId := range sessionIds {
+			_ = server.deleteSession(sessionId)
+		}
+
+		cancelServer()
+		server.ProxyTransport.CloseIdleConnections()
+	})
+
+	return server
+}
+
+func newTestClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+
+	return &http.Client{
+		Jar:     jar,
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func doTestRequest(t *testing.T, client *http.Client, method string, requestUrl string) (*http.Response, string) {
+	t.Helper()
+
+	request, err := http.NewRequest(method, requestUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, requestUrl, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, string(body)
+}
+
+func waitForTestSessionReady(t *testing.T, client *http.Client, baseUrl string, sessionId string) sessionStatusResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		response, body := doTestRequest(t, client, http.MethodGet, baseUrl+"/status/"+sessionId)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("status returned %d: %s", response.StatusCode, body)
+		}
+
+		var status sessionStatusResponse
+		if err := json.Unmarshal([]byte(body), &status); err != nil {
+			t.Fatalf("status returned malformed json %q: %v", body, err)
+		}
+
+		if status.Ready {
+			return status
+		}
+		if status.Phase == SessionPhaseFailed {
+			t.Fatalf("session failed: %s", status.LastError)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session did not become ready, last phase %s", status.Phase)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func startTestSession(t *testing.T, client *http.Client, baseUrl string) (string, sessionStatusResponse) {
+	t.Helper()
+
+	response, _ := doTestRequest(t, client, http.MethodGet, baseUrl+"/")
+	if response.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("new session returned %d, expected a redirect", response.StatusCode)
+	}
+
+	sessionPath := response.Header.Get("Location")
+	sessionId := strings.TrimSuffix(strings.TrimPrefix(sessionPath, "/session/"), "/")
+	if sessionId == "" || strings.Contains(sessionId, "/") {
+		t.Fatalf("unexpected session redirect %q", sessionPath)
+	}
+
+	return sessionId, waitForTestSessionReady(t, client, baseUrl, sessionId)
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	server := newTestServer(t)
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	client := newTestClient()
+
+	sessionId, status := startTestSession(t, client, httpServer.URL)
+	sessionPath := "/session/" + sessionId + "/"
+	if status.Access != "owner" {
+		t.Fatalf("status access is %q, expected owner", status.Access)
+	}
+	if !status.CanExtend {
+		t.Fatal("ready session cannot be extended")
+	}
+
+	response, body := doTestRequest(t, client, http.MethodGet, httpServer.URL+sessionPath)
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, "Fake dashboard") || !strings.Contains(body, "/session/"+sessionId) {
+		t.Fatalf("proxied dashboard returned %d: %s", response.StatusCode, body)
+	}
+
+	response, _ = doTestRequest(t, newTestClient(), http.MethodGet, httpServer.URL+sessionPath)
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("visitor without the owner cookie got %d, expected 403", response.StatusCode)
+	}
+
+	response, body = doTestRequest(t, client, http.MethodPost, httpServer.URL+sessionPath+"extend")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("extend returned %d: %s", response.StatusCode, body)
+	}
+
+	var extended sessionExtendResponse
+	if err := json.Unmarshal([]byte(body), &extended); err != nil {
+		t.Fatal(err)
+	}
+	if !extended.Extended || extended.SecondsLeft <= int64(server.SessionTtl.Seconds()) {
+		t.Fatalf("extend did not push the expiry out: %+v", extended)
+	}
+
+	response, body = doTestRequest(t, client, http.MethodPost, httpServer.URL+sessionPath+"end")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("end returned %d: %s", response.StatusCode, body)
+	}
+
+	response, body = doTestRequest(t, client, http.MethodGet, httpServer.URL+"/status/"+sessionId)
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, `"exists":false`) {
+		t.Fatalf("ended session still reported %d: %s", response.StatusCode, body)
+	}
+
+	if projects, _ := server.Runtime.ListProjects(); len(projects) != 0 {
+		t.Fatalf("fake runtime still has stacks after the session ended: %v", projects)
+	}
+}
+
+func TestLogPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxLineLength int
+		writes        []string
+		flush         bool
+		expected      []string
+	}{
+		{name: "lf lines", writes: []string{"first\nsecond\n"}, expected: []string{"first", "second"}},
+		{name: "crlf lines", writes: []string{"first\r\nsecond\r\n"}, expected: []string{"first", "second"}},
+		{name: "crlf split between writes", writes: []string{"first\r", "\nsecond\r\n"}, expected: []string{"first", "second"}},
+		{name: "partial writes", writes: []string{"hel", "lo\nwor", "ld\n"}, expected: []string{"hello", "world"}},
+		{name: "pending line waits for newline", writes: []string{"complete\nincomplete"}, expected: []string{"complete"}},
+		{name: "flush emits pending line", writes: []string{"complete\nincomplete"}, flush: true, expected: []string{"complete", "incomplete"}},
+		{name: "empty lines skipped", writes: []string{"\n\r\nline\n\n"}, expected: []string{"line"}},
+		{name: "multi-byte rune split between writes", writes: []string{"caf\xc3", "\xa9\n"}, expected: []string{"café"}},
+		{name: "four byte rune split byte by byte", writes: []string{"\xf0", "\x9f", "\x98", "\x80!\n"}, expected: []string{"😀!"}},
+		{name: "invalid utf-8 replaced", writes: []string{"a\xffb\n"}, expected: []string{"a\uFFFDb"}},
+		{name: "overlong line cut at limit", maxLineLength: 4, writes: []string{"abcdefghij\n"}, expected: []string{"abcd", "efgh", "ij"}},
+		{name: "overlong line cut on rune boundary", maxLineLength: 5, writes: []string{"abcd€fg\n"}, expected: []string{"abcd", "€fg"}},
+		{name: "overlong line cut across writes", maxLineLength: 5, writes: []string{"abc", "d€", "fg\n"}, expected: []string{"abcd", "€fg"}},
+		{name: "rune longer than limit emitted whole", maxLineLength: 2, writes: []string{"€€\n"}, expected: []string{"€", "€"}},
+		{name: "rune longer than limit split between writes", maxLineLength: 1, writes: []string{"a\xf0\x9f", "\x98\x80b\n"}, expected: []string{"a", "😀", "b"}},
+		{name: "invalid bytes with a short limit", maxLineLength: 1, writes: []string{"\xff\xfe\n"}, expected: []string{"\uFFFD", "\uFFFD"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := []string{}
+			writer := &LogPrefixWriter{
+				Stream:        "stdout",
+				MaxLineLength: test.maxLineLength,
+				HandleLine: func(stream string, line string) {
+					if stream != "stdout" {
+						t.Errorf("line %q tagged with stream %q", line, stream)
+					}
+					lines = append(lines, line)
+				},
+			}
+
+			for _, data := range test.writes {
+				written, err := writer.Write([]byte(data))
+				if err != nil || written != len(data) {
+					t.Fatalf("Write(%q) = %d, %v", data, written, err)
+				}
+			}
+			if test.flush {
+				writer.Flush()
+			}
+
+			if !slices.Equal(lines, test.expected) {
+				t.Fatalf("lines = %q, expected %q", lines, test.expected)
+			}
+			for _, line := range lines {
+				if !utf8.ValidString(line) {
+					t.Fatalf("line %q is not valid UTF-8", line)
+				}
+			}
+		})
+	}
+}
+
+func TestLogPrefixWriterClose(t *testing.T) {
+	lines := []string{}
+	writer := &LogPrefixWriter{
+		Stream: "stderr",
+		HandleLine: func(stream string, line string) {
+			lines = append(lines, stream+": "+line)
+		},
+	}
+
+	_, _ = writer.Write([]byte("unterminated"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(lines, []string{"stderr: unterminated"}) {
+		t.Fatalf("close did not flush the pending line: %q", lines)
+	}
+
+	if _, err := writer.Write([]byte("late\n")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("write after close returned %v, expected io.ErrClosedPipe", err)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("write after close emitted lines: %q", lines)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MetricCounter struct {
+	Name       string
+	Help       string
+	LabelNames []string
+	Values     map[string]float64
+	Mutex      sync.Mutex
+}
+
+type MetricHistogram struct {
+	Name       string
+	Help       string
+	LabelNames []string
+	Buckets    []float64
+	Series     map[string]*metricHistogramSeries
+	Mutex      sync.Mutex
+}
+
+type metricHistogramSeries struct {
+	BucketCounts []uint64
+	Sum          float64
+	Count        uint64
+}
+
+type ControllerMetrics struct {
+	SessionsCreated       *MetricCounter
+	SessionsFailed        *MetricCounter
+	SessionsExpired       *MetricCounter
+	SessionsDeleted       *MetricCounter
+	PhaseDuration         *MetricHistogram
+	HealthProbes          *MetricCounter
+	ProxyRequests         *MetricCounter
+	ProxyRequestDuration  *MetricHistogram
+	DockerCommandFailures *MetricCounter
+	LogStreamReconnects   *MetricCounter
+	ContainerRestarts     *MetricCounter
+	WebSocketsOpened      *MetricCounter
+	WebSocketBytes        *MetricCounter
+	WebSocketDuration     *MetricHistogram
+	SpectatorInputDropped *MetricCounter
+}
+
+var controllerMetrics = &ControllerMetrics{
+	SessionsCreated:       newMetricCounter("controller_sessions_created_total", "Sessions accepted by the controller.", "pooled"),
+	SessionsFailed:        newMetricCounter("controller_sessions_failed_total", "Sessions that failed, by the phase they failed in.", "phase"),
+	SessionsExpired:       newMetricCounter("controller_sessions_expired_total", "Sessions deleted because they ran out of time.", "reason"),
+	SessionsDeleted:       newMetricCounter("controller_sessions_deleted_total", "Sessions removed from the controller."),
+	PhaseDuration:         newMetricHistogram("controller_session_phase_duration_seconds", "Time sessions spent in each provisioning phase.", []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600}, "phase"),
+	HealthProbes:          newMetricCounter("controller_health_probes_total", "Session service readiness probes, by result.", "result"),
+	ProxyRequests:         newMetricCounter("controller_proxy_requests_total", "Requests proxied into sessions, by upstream and status code.", "upstream", "code"),
+	ProxyRequestDuration:  newMetricHistogram("controller_proxy_request_duration_seconds", "Latency of requests proxied into sessions, by upstream.", []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "upstream"),
+	DockerCommandFailures: newMetricCounter("controller_docker_command_failures_total", "Docker commands that exited with an error, by command.", "command"),
+	LogStreamReconnects:   newMetricCounter("controller_log_stream_reconnects_total", "Container log streams reattached after they ended, by service.", "service"),
+	ContainerRestarts:     newMetricCounter("controller_container_restarts_total", "Session containers that came back after exiting, by service.", "service"),
+	WebSocketsOpened:      newMetricCounter("controller_proxy_websockets_opened_total", "WebSocket connections tunnelled into sessions, by upstream.", "upstream"),
+	WebSocketBytes:        newMetricCounter("controller_proxy_websocket_bytes_total", "Bytes carried by closed WebSocket tunnels, by upstream and direction.", "upstream", "direction"),
+	WebSocketDuration:     newMetricHistogram("controller_proxy_websocket_duration_seconds", "How long WebSocket tunnels stayed open, by upstream.", []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200}, "upstream"),
+	SpectatorInputDropped: newMetricCounter("controller_proxy_spectator_input_dropped_total", "WebSocket frames from view only visitors that were not forwarded, by upstream.", "upstream"),
+}
+
+func newMetricCounter(name string, help string, labelNames ...string) *MetricCounter {
+	return &MetricCounter{Name: name, Help: help, LabelNames: labelNames, Values: map[string]float64{}}
+}
+
+func newMetricHistogram(name string, help string, buckets []float64, labelNames ...string) *MetricHistogram {
+	return &MetricHistogram{Name: name, Help: help, LabelNames: labelNames, Buckets: buckets, Series: map[string]*metricHistogramSeries{}}
+}
+
+func (counter *MetricCounter) inc(labelValues ...string) {
+	counter.add(1, labelValues...)
+}
+
+func (counter *MetricCounter) add(value float64, labelValues ...string) {
+	counter.Mutex.Lock()
+	counter.Values[formatMetricLabels(counter.LabelNames, labelValues)] += value
+	counter.Mutex.Unlock()
+}
+
+func (counter *MetricCounter) writeTo(writer io.Writer) {
+	counter.Mutex.Lock()
+	defer counter.Mutex.Unlock()
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s counter\n", counter.Name, counter.Help, counter.Name)
+	if len(counter.LabelNames) == 0 && len(counter.Values) == 0 {
+		fmt.Fprintf(writer, "%s 0\n", counter.Name)
+	}
+
+	for _, labels := range slices.Sorted(maps.Keys(counter.Values)) {
+		fmt.Fprintf(writer, "%s%s %s\n", counter.Name, labels, formatMetricValue(counter.Values[labels]))
+	}
+}
+
+func (histogram *MetricHistogram) observe(duration time.Duration, labelValues ...string) {
+	value := duration.Seconds()
+	labels := formatMetricLabels(histogram.LabelNames, labelValues)
+
+	histogram.Mutex.Lock()
+	defer histogram.Mutex.Unlock()
+
+	series, ok := histogram.Series[labels]
+	if !ok {
+		series = &metricHistogramSeries{BucketCounts: make([]uint64, len(histogram.Buckets))}
+		histogram.Series[labels] = series
+	}
+
+	for index, bucket := range histogram.Buckets {
+		if value <= bucket {
+			series.BucketCounts[index]++
+		}
+	}
+	series.Sum += value
+	series.Count++
+}
+
+func (histogram *MetricHistogram) writeTo(writer io.Writer) {
+	histogram.Mutex.Lock()
+	defer histogram.Mutex.Unlock()
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s histogram\n", histogram.Name, histogram.Help, histogram.Name)
+
+	for _, labels := range slices.Sorted(maps.Keys(histogram.Series)) {
+		series := histogram.Series[labels]
+		labelPrefix := strings.TrimSuffix(labels, "}")
+		if labelPrefix == "" {
+			labelPrefix = "{"
+		} else {
+			labelPrefix += ","
+		}
+
+		for index, bucket := range histogram.Buckets {
+			fmt.Fprintf(writer, "%s_bucket%sle=\"%s\"} %d\n", histogram.Name, labelPrefix, formatMetricValue(bucket), series.BucketCounts[index])
+		}
+		fmt.Fprintf(writer, "%s_bucket%sle=\"+Inf\"} %d\n", histogram.Name, labelPrefix, series.Count)
+		fmt.Fprintf(writer, "%s_sum%s %s\n", histogram.Name, labels, formatMetricValue(series.Sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", histogram.Name, labels, series.Count)
+	}
+}
+
+func writeMetricGauge(writer io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatMetricValue(value))
+}
+
+func formatMetricLabels(labelNames []string, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+
+	labels := strings.Builder{}
+	labels.WriteByte('{')
+	for index, labelName := range labelNames {
+		if index > 0 {
+			labels.WriteByte(',')
+		}
+
+		labelValue := ""
+		if index < len(labelValues) {
+			labelValue = labelValues[index]
+		}
+
+		labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labelValue)
+		fmt.Fprintf(&labels, "%s=\"%s\"", labelName, labelValue)
+	}
+	labels.WriteByte('}')
+
+	return labels.String()
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (server *Server) handleMetrics(writer http.ResponseWriter, request *http.Request) {
+	if server.MetricsToken != "" && !isBearerTokenValid(request, server.MetricsToken) {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="controller"`)
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionsByPhase := map[SessionPhase]int{}
+	degradedSessions := 0
+	openWebSockets := 0
+
+	server.SessionsMutex.RLock()
+	for _, session := range server.Sessions {
+		if !session.Pooled {
+			sessionsByPhase[session.Phase]++
+		}
+		if session.DegradedReason != "" {
+			degradedSessions++
+		}
+		openWebSockets += session.Proxy.stats().OpenWebSockets
+	}
+	activeSessions := server.countActiveSessionsLocked()
+	queueLength := len(server.Queue)
+	warmPoolReady, warmPoolStarting := server.countPooledSessionsLocked()
+	server.SessionsMutex.RUnlock()
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetricGauge(writer, "controller_sessions_active", "Sessions holding a slot, including the warm pool.", float64(activeSessions))
+	writeMetricGauge(writer, "controller_sessions_max", "Configured maximum number of active sessions.", float64(server.MaxSessions))
+	writeMetricGauge(writer, "controller_queue_length", "Visitors waiting for a free slot.", float64(queueLength))
+	writeMetricGauge(writer, "controller_warm_pool_ready", "Warm pool sessions ready to be adopted.", float64(warmPoolReady))
+	writeMetricGauge(writer, "controller_warm_pool_starting", "Warm pool sessions still provisioning.", float64(warmPoolStarting))
+	writeMetricGauge(writer, "controller_sessions_degraded", "Sessions with a crash looping client or void container.", float64(degradedSessions))
+	writeMetricGauge(writer, "controller_proxy_websockets_open", "WebSocket tunnels currently open into sessions.", float64(openWebSockets))
+	writeMetricGauge(writer, "controller_log_streams_active", "Container log streams being followed.", float64(server.ActiveLogStreams.Load()))
+
+	fmt.Fprintf(writer, "# HELP controller_sessions Visitor sessions by phase.\n# TYPE controller_sessions gauge\n")
+	for _, phase := range sessionPhases {
+		fmt.Fprintf(writer, "controller_sessions%s %d\n", formatMetricLabels([]string{"phase"}, []string{string(phase)}), sessionsByPhase[phase])
+	}
+
+	controllerMetrics.SessionsCreated.writeTo(writer)
+	controllerMetrics.SessionsFailed.writeTo(writer)
+	controllerMetrics.SessionsExpired.writeTo(writer)
+	controllerMetrics.SessionsDeleted.writeTo(writer)
+	controllerMetrics.PhaseDuration.writeTo(writer)
+	controllerMetrics.HealthProbes.writeTo(writer)
+	controllerMetrics.ProxyRequests.writeTo(writer)
+	controllerMetrics.ProxyRequestDuration.writeTo(writer)
+	controllerMetrics.DockerCommandFailures.writeTo(writer)
+	controllerMetrics.LogStreamReconnects.writeTo(writer)
+	controllerMetrics.ContainerRestarts.writeTo(writer)
+	controllerMetrics.WebSocketsOpened.writeTo(writer)
+	controllerMetrics.WebSocketBytes.writeTo(writer)
+	controllerMetrics.WebSocketDuration.writeTo(writer)
+	controllerMetrics.SpectatorInputDropped.writeTo(writer)
+
+	fmt.Fprintf(writer, "# HELP controller_reconciler_runs_total Reconciler passes.\n# TYPE controller_reconciler_runs_total counter\ncontroller_reconciler_runs_total %d\n", server.ReconcilerStats.Runs.Load())
+	fmt.Fprintf(writer, "# HELP controller_reconciler_failures_total Reconciler passes that could not list projects.\n# TYPE controller_reconciler_failures_total counter\ncontroller_reconciler_failures_total %d\n", server.ReconcilerStats.Failures.Load())
+	fmt.Fprintf(writer, "# HELP controller_reconciler_orphaned_projects_removed_total Compose projects removed because no session owned them.\n# TYPE controller_reconciler_orphaned_projects_removed_total counter\ncontroller_reconciler_orphaned_projects_removed_total %d\n", server.ReconcilerStats.OrphanedProjectsRemoved.Load())
+	fmt.Fprintf(writer, "# HELP controller_reconciler_expired_sessions_removed_total Expired sessions removed by the reconciler.\n# TYPE controller_reconciler_expired_sessions_removed_total counter\ncontroller_reconciler_expired_sessions_removed_total %d\n", server.ReconcilerStats.ExpiredSessionsRemoved.Load())
+}
+
+func proxyUpstreamName(restPath string) string {
+	firstSegment, _, _ := strings.Cut(strings.TrimPrefix(restPath, "/"), "/")
+
+	switch firstSegment {
+	case "void", "vnc", "itzg":
+		return firstSegment
+	default:
+		return "dashboard"
+	}
+}
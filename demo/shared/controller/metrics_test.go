@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionsGaugeCoversEveryPhase(t *testing.T) {
+	server := newTestServer(t)
+	restarting := newTestReadySession(server, "restarting-session")
+	server.SessionsMutex.Lock()
+	restarting.Phase = SessionPhaseRestarting
+	server.SessionsMutex.Unlock()
+
+	recorder := httptest.NewRecorder()
+	server.handleMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	metrics := recorder.Body.String()
+
+	for _, phase := range sessionPhases {
+		expected := 0
+		if phase == SessionPhaseRestarting {
+			expected = 1
+		}
+
+		line := fmt.Sprintf("controller_sessions%s %d\n", formatMetricLabels([]string{"phase"}, []string{string(phase)}), expected)
+		if !strings.Contains(metrics, line) {
+			t.Fatalf("metrics are missing %q:\n%s", line, metrics)
+		}
+	}
+}
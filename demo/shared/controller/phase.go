@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+)
+
+type SessionPhase string
+
+const (
+	SessionPhaseQueued           SessionPhase = "queued"
+	SessionPhaseComposing        SessionPhase = "composing"
+	SessionPhaseWaitingClientApi SessionPhase = "waiting-client-api"
+	SessionPhaseLaunchingGame    SessionPhase = "launching-game"
+	SessionPhaseJoining          SessionPhase = "joining"
+	SessionPhaseProbing          SessionPhase = "probing"
+	SessionPhaseReady            SessionPhase = "ready"
+	SessionPhaseRestarting       SessionPhase = "restarting"
+	SessionPhaseFailed           SessionPhase = "failed"
+)
+
+// Every phase a session can be in, in the order a session moves through them
+var sessionPhases = []SessionPhase{
+	SessionPhaseQueued,
+	SessionPhaseComposing,
+	SessionPhaseWaitingClientApi,
+	SessionPhaseLaunchingGame,
+	SessionPhaseJoining,
+	SessionPhaseProbing,
+	SessionPhaseReady,
+	SessionPhaseRestarting,
+	SessionPhaseFailed,
+}
+
+type SessionPhaseTransition struct {
+	Phase      SessionPhase `json:"phase"`
+	StartedUtc time.Time    `json:"startedUtc"`
+}
+
+var sessionPhaseSteps = []struct {
+	Phase SessionPhase
+	Label string
+}{
+	{SessionPhaseQueued, "Waiting for a free slot"},
+	{SessionPhaseComposing, "Starting containers"},
+	{SessionPhaseWaitingClientApi, "Waiting for the Minecraft client"},
+	{SessionPhaseLaunchingGame, "Launching the game"},
+	{SessionPhaseJoining, "Joining the server"},
+	{SessionPhaseProbing, "Checking services"},
+	{SessionPhaseReady, "Ready"},
+}
+
+func sessionFailureReason(phase SessionPhase) string {
+	switch phase {
+	case SessionPhaseComposing:
+		return "The demo containers could not be started."
+	case SessionPhaseWaitingClientApi:
+		return "The Minecraft client did not start in time."
+	case SessionPhaseLaunchingGame:
+		return "The game failed to launch."
+	case SessionPhaseJoining:
+		return "The Minecraft client could not join the server."
+	case SessionPhaseProbing:
+		return "The session services did not become reachable."
+	default:
+		return "The session could not be started."
+	}
+}
+
+func (session *Session) setPhaseLocked(phase SessionPhase) {
+	if previousPhase := session.Phase; previousPhase != phase && previousPhase != SessionPhaseReady && previousPhase != SessionPhaseFailed && len(session.PhaseHistory) > 0 {
+		controllerMetrics.PhaseDuration.observe(time.Since(session.PhaseHistory[len(session.PhaseHistory)-1].StartedUtc), string(previousPhase))
+	}
+
+	session.Phase = phase
+	session.PhaseHistory = append(session.PhaseHistory, SessionPhaseTransition{Phase: phase, StartedUtc: time.Now().UTC()})
+	session.Watcher.notify()
+}
+
+func (server *Server) setSessionPhase(session *Session, phase SessionPhase) {
+	server.SessionsMutex.Lock()
+	session.setPhaseLocked(phase)
+	logger := session.loggerLocked()
+	server.SessionsMutex.Unlock()
+
+	logger.Info("Entered phase")
+}
+
+func (server *Server) failSession(session *Session, err error) {
+	server.SessionsMutex.Lock()
+	session.LastError = err.Error()
+	session.FailureReason = sessionFailureReason(session.Phase)
+	server.recordFailureLocked(session, err)
+	controllerMetrics.SessionsFailed.inc(string(session.Phase))
+	logger := session.loggerLocked()
+	session.setPhaseLocked(SessionPhaseFailed)
+	server.SessionsMutex.Unlock()
+
+	logger.Error("Session failed", "error", err)
+}
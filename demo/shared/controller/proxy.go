@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SessionProxy struct {
+	SessionId     string
+	DashboardHost string
+	ReverseProxy  *httputil.ReverseProxy
+	Dialer        *net.Dialer
+	Logger        func() *slog.Logger
+
+	Tunnels       map[*sessionTunnel]bool
+	TunnelsOpened int64
+	BytesIn       int64
+	BytesOut      int64
+	Closed        bool
+	Mutex         sync.Mutex
+}
+
+type sessionTunnel struct {
+	Upstream     string
+	OpenedUtc    time.Time
+	ClientConn   net.Conn
+	UpstreamConn net.Conn
+	InputFilter  webSocketInputFilter
+	Logger       func() *slog.Logger
+	BytesIn      atomic.Int64
+	BytesOut     atomic.Int64
+}
+
+type SessionProxyStats struct {
+	OpenWebSockets    int   `json:"openWebSockets"`
+	WebSocketsOpened  int64 `json:"webSocketsOpened"`
+	WebSocketBytesIn  int64 `json:"webSocketBytesIn"`
+	WebSocketBytesOut int64 `json:"webSocketBytesOut"`
+}
+
+const proxyHandshakeTimeout = 10 * time.Second
+
+var hopByHopUpgradeHeaders = []string{"Keep-Alive", "Proxy-Connection", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding"}
+
+func (server *Server) setupProxyTransport() {
+	server.ProxyDialer = &net.Dialer{
+		Timeout:   server.ProxyDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// One transport for every session so keep-alive connections to each dashboard are reused between requests
+	server.ProxyTransport = &http.Transport{
+		DialContext:           server.ProxyDialer.DialContext,
+		MaxIdleConns:          server.ProxyMaxIdleConns,
+		MaxIdleConnsPerHost:   server.ProxyMaxIdleConnsPerHost,
+		MaxConnsPerHost:       server.ProxyMaxConnsPerHost,
+		IdleConnTimeout:       server.ProxyIdleConnTimeout,
+		ResponseHeaderTimeout: server.ProxyResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+}
+
+// Sessions get their proxy when they become ready, this only covers sessions that reached ready without one
+func (server *Server) sessionProxy(session *Session) *SessionProxy {
+	server.SessionsMutex.Lock()
+	defer server.SessionsMutex.Unlock()
+
+	if session.Proxy == nil {
+		server.buildSessionProxyLocked(session)
+	}
+
+	return session.Proxy
+}
+
+func (server *Server) buildSessionProxyLocked(session *Session) {
+	previousProxy := session.Proxy
+	if previousProxy != nil && previousProxy.SessionId == session.Id && previousProxy.DashboardHost == session.DashboardHost {
+		return
+	}
+
+	sessionProxy := server.newSessionProxy(session.Id, session.DashboardHost)
+	// Tunnels outlive the session map entry when it is deleted, the session itself still knows its last phase
+	sessionProxy.Logger = func() *slog.Logger {
+		return server.sessionLogger(session)
+	}
+	session.Proxy = sessionProxy
+	previousProxy.close()
+}
+
+func (server *Server) newSessionProxy(sessionId string, dashboardHost string) *SessionProxy {
+	proxy := &SessionProxy{
+		SessionId:     sessionId,
+		DashboardHost: dashboardHost,
+		Dialer:        server.ProxyDialer,
+		Tunnels:       map[*sessionTunnel]bool{},
+		Logger: func() *slog.Logger {
+			return server.sessionIdLogger(sessionId)
+		},
+	}
+
+	targetUrl := &url.URL{
+		Scheme: "http",
+		Host:   dashboardHost,
+	}
+
+	proxy.ReverseProxy = &httputil.ReverseProxy{
+		Transport: server.ProxyTransport,
+		Director: func(proxyRequest *http.Request) {
+			restPath := proxyRequest.URL.Path
+
+			proxyRequest.URL.Scheme = targetUrl.Scheme
+			proxyRequest.URL.Host = targetUrl.Host
+			proxyRequest.URL.Path = restPath
+			proxyRequest.URL.RawPath = restPath
+			proxyRequest.Host = targetUrl.Host
+		},
+		ErrorHandler: func(proxyWriter http.ResponseWriter, proxyRequest *http.Request, proxyError error) {
+			if errors.Is(proxyError, context.Canceled) {
+				return
+			}
+
+			proxy.Logger().Warn("Upstream not reachable yet", "upstream", proxyUpstreamName(proxyRequest.URL.Path), "error", proxyError)
+			server.writeSessionStartingHtml(proxyWriter, sessionId)
+		},
+	}
+
+	return proxy
+}
+
+func isWebSocketUpgrade(request *http.Request) bool {
+	return strings.EqualFold(request.Header.Get("Upgrade"), "websocket") && headerContainsToken(request.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP expects the request path to already be relative to the session root and X-Path-Prefix and X-View-Only to be set
+func (proxy *SessionProxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if isWebSocketUpgrade(request) {
+		proxy.serveWebSocket(writer, request)
+		return
+	}
+
+	proxy.ReverseProxy.ServeHTTP(writer, request)
+}
+
+func (proxy *SessionProxy) serveWebSocket(writer http.ResponseWriter, request *http.Request) {
+	restPath := request.URL.Path
+	upstream := proxyUpstreamName(restPath)
+	upstreamHost := hostWithDefaultPort(proxy.DashboardHost, "80")
+
+	fail := func(err error) {
+		proxy.ReverseProxy.ErrorHandler(writer, request, err)
+	}
+
+	var inputFilter webSocketInputFilter
+	if request.Header.Get("X-View-Only") == "true" {
+		var err error
+		inputFilter, err = newSpectatorInputFilter(upstream)
+		if err != nil {
+			http.Error(writer, "Forbidden, this share link is view only", http.StatusForbidden)
+			return
+		}
+	}
+
+	handshakeContext, cancelHandshake := context.WithTimeout(request.Context(), proxyHandshakeTimeout)
+	defer cancelHandshake()
+
+	upstreamConn, err := proxy.Dialer.DialContext(handshakeContext, "tcp", upstreamHost)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	outboundRequest := request.Clone(handshakeContext)
+	outboundRequest.URL = &url.URL{Path: restPath, RawPath: restPath, RawQuery: request.URL.RawQuery}
+	outboundRequest.Host = proxy.DashboardHost
+	for _, header := range hopByHopUpgradeHeaders {
+		outboundRequest.Header.Del(header)
+	}
+	outboundRequest.Header.Set("Connection", "Upgrade")
+	if inputFilter != nil {
+		// Compressed frames could not be inspected
+		outboundRequest.Header.Del("Sec-WebSocket-Extensions")
+	}
+	if remoteHost, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		if prior := request.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			remoteHost = strings.Join(prior, ", ") + ", " + remoteHost
+		}
+		outboundRequest.Header.Set("X-Forwarded-For", remoteHost)
+	}
+
+	_ = upstreamConn.SetDeadline(time.Now().Add(proxyHandshakeTimeout))
+	if err := outboundRequest.Write(upstreamConn); err != nil {
+		_ = upstreamConn.Close()
+		fail(err)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	response, err := http.ReadResponse(upstreamReader, outboundRequest)
+	if err != nil {
+		_ = upstreamConn.Close()
+		fail(err)
+		return
+	}
+	_ = upstreamConn.SetDeadline(time.Time{})
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		defer upstreamConn.Close()
+		defer response.Body.Close()
+
+		for name, values := range response.Header {
+			writer.Header()[name] = values
+		}
+		writer.WriteHeader(response.StatusCode)
+		_, _ = io.Copy(writer, response.Body)
+		return
+	}
+
+	clientConn, clientBuffer, err := http.NewResponseController(writer).Hijack()
+	if err != nil {
+		_ = upstreamConn.Close()
+		fail(fmt.Errorf("failed to hijack websocket connection: %w", err))
+		return
+	}
+
+	if recordingWriter, ok := writer.(*statusRecordingWriter); ok {
+		recordingWriter.StatusCode = http.StatusSwitchingProtocols
+	}
+
+	tunnel := &sessionTunnel{
+		Upstream:     upstream,
+		OpenedUtc:    time.Now().UTC(),
+		ClientConn:   clientConn,
+		UpstreamConn: upstreamConn,
+		InputFilter:  inputFilter,
+		Logger:       proxy.Logger,
+	}
+	if !proxy.registerTunnel(tunnel) {
+		_ = clientConn.Close()
+		_ = upstreamConn.Close()
+		return
+	}
+	defer proxy.unregisterTunnel(tunnel)
+
+	if _, err := fmt.Fprintf(clientBuffer, "HTTP/1.1 %s\r\n", response.Status); err != nil {
+		return
+	}
+	if err := response.Header.Write(clientBuffer); err != nil {
+		return
+	}
+	if _, err := clientBuffer.WriteString("\r\n"); err != nil {
+		return
+	}
+	if err := clientBuffer.Flush(); err != nil {
+		return
+	}
+
+	tunnel.pipe(clientBuffer.Reader, upstreamReader)
+}
+
+func (tunnel *sessionTunnel) pipe(clientReader io.Reader, upstreamReader io.Reader) {
+	copyDone := make(chan struct{}, 2)
+
+	// Tunnels parse untrusted input, a panic in one must not take the controller and every other session down
+	runCopy := func(direction string, copyStream func()) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				tunnel.Logger().Error("WebSocket tunnel panicked", "upstream", tunnel.Upstream, "direction", direction, "panic", recovered)
+			}
+			copyDone <- struct{}{}
+		}()
+
+		copyStream()
+	}
+
+	go runCopy("in", func() {
+		upstreamWriter := &countingWriter{Writer: tunnel.UpstreamConn, Count: &tunnel.BytesIn}
+		if tunnel.InputFilter == nil {
+			_, _ = io.Copy(upstreamWriter, clientReader)
+		} else {
+			err := copyFilteredWebSocket(upstreamWriter, clientReader, tunnel.InputFilter, func() {
+				controllerMetrics.SpectatorInputDropped.inc(tunnel.Upstream)
+			})
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				tunnel.Logger().Debug("Spectator WebSocket closed", "upstream", tunnel.Upstream, "error", err)
+			}
+		}
+	})
+	go runCopy("out", func() {
+		_, _ = io.Copy(&countingWriter{Writer: tunnel.ClientConn, Count: &tunnel.BytesOut}, upstreamReader)
+	})
+
+	// Either side hanging up ends the tunnel, closing both unblocks the other copy
+	<-copyDone
+	tunnel.close()
+	<-copyDone
+}
+
+type countingWriter struct {
+	Writer io.Writer
+	Count  *atomic.Int64
+}
+
+func (writer *countingWriter) Write(data []byte) (int, error) {
+	written, err := writer.Writer.Write(data)
+	writer.Count.Add(int64(written))
+	return written, err
+}
+
+func (tunnel *sessionTunnel) close() {
+	_ = tunnel.ClientConn.Close()
+	_ = tunnel.UpstreamConn.Close()
+}
+
+func (proxy *SessionProxy) registerTunnel(tunnel *sessionTunnel) bool {
+	proxy.Mutex.Lock()
+	defer proxy.Mutex.Unlock()
+
+	if proxy.Closed {
+		return false
+	}
+
+	proxy.Tunnels[tunnel] = true
+	proxy.TunnelsOpened++
+	controllerMetrics.WebSocketsOpened.inc(tunnel.Upstream)
+	return true
+}
+
+func (proxy *SessionProxy) unregisterTunnel(tunnel *sessionTunnel) {
+	tunnel.close()
+
+	proxy.Mutex.Lock()
+	delete(proxy.Tunnels, tunnel)
+	proxy.BytesIn += tunnel.BytesIn.Load()
+	proxy.BytesOut += tunnel.BytesOut.Load()
+	proxy.Mutex.Unlock()
+
+	duration := time.Since(tunnel.OpenedUtc)
+	controllerMetrics.WebSocketBytes.add(float64(tunnel.BytesIn.Load()), tunnel.Upstream, "in")
+	controllerMetrics.WebSocketBytes.add(float64(tunnel.BytesOut.Load()), tunnel.Upstream, "out")
+	controllerMetrics.WebSocketDuration.observe(duration, tunnel.Upstream)
+
+	tunnel.Logger().Debug("WebSocket closed", "upstream", tunnel.Upstream, "duration", duration.Truncate(time.Millisecond), "bytes_in", tunnel.BytesIn.Load(), "bytes_out", tunnel.BytesOut.Load())
+}
+
+func (proxy *SessionProxy) stats() SessionProxyStats {
+	if proxy == nil {
+		return SessionProxyStats{}
+	}
+
+	proxy.Mutex.Lock()
+	defer proxy.Mutex.Unlock()
+
+	stats := SessionProxyStats{
+		OpenWebSockets:    len(proxy.Tunnels),
+		WebSocketsOpened:  proxy.TunnelsOpened,
+		WebSocketBytesIn:  proxy.BytesIn,
+		WebSocketBytesOut: proxy.BytesOut,
+	}
+	for tunnel := range proxy.Tunnels {
+		stats.WebSocketBytesIn += tunnel.BytesIn.Load()
+		stats.WebSocketBytesOut += tunnel.BytesOut.Load()
+	}
+
+	return stats
+}
+
+func (proxy *SessionProxy) close() int {
+	if proxy == nil {
+		return 0
+	}
+
+	proxy.Mutex.Lock()
+	defer proxy.Mutex.Unlock()
+
+	proxy.Closed = true
+	for tunnel := range proxy.Tunnels {
+		tunnel.close()
+	}
+
+	return len(proxy.Tunnels)
+}
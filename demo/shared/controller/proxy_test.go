@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDeletingSessionKeepsOtherDashboardConnections(t *testing.T) {
+	server := newTestServer(t)
+
+	newConnections := atomic.Int64{}
+	dashboard := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.WriteString(writer, "dashboard")
+	}))
+	dashboard.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConnections.Add(1)
+		}
+	}
+	dashboard.Start()
+	defer dashboard.Close()
+
+	liveSession := newTestReadySession(server, "live-session")
+	liveSession.Proxy = server.newSessionProxy(liveSession.Id, dashboard.Listener.Addr().String())
+	deletedSession := newTestReadySession(server, "deleted-session")
+
+	proxyRequest := func() {
+		t.Helper()
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		liveSession.Proxy.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("proxy returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+
+	proxyRequest()
+	if err := server.deleteSession(deletedSession.Id); err != nil {
+		t.Fatal(err)
+	}
+	proxyRequest()
+
+	if connections := newConnections.Load(); connections != 1 {
+		t.Fatalf("dashboard saw %d connections, deleting another session dropped the kept alive one", connections)
+	}
+}
+
+// Compares the cached per-session proxy on the shared transport with building a proxy and transport for every request
+func BenchmarkSessionProxy(b *testing.B) {
+	server := newTestServer(b)
+	dashboard := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.WriteString(writer, "<!doctype html><title>Dashboard</title>")
+	}))
+	defer dashboard.Close()
+
+	dashboardHost := dashboard.Listener.Addr().String()
+	proxyRequest := func(b *testing.B, sessionProxy *SessionProxy) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Path-Prefix", "session/benchmark/")
+		recorder := httptest.NewRecorder()
+
+		sessionProxy.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("proxy returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		sessionProxy := server.newSessionProxy("benchmark", dashboardHost)
+		for b.Loop() {
+			proxyRequest(b, sessionProxy)
+		}
+	})
+
+	b.Run("per-request", func(b *testing.B) {
+		for b.Loop() {
+			transport := server.ProxyTransport.Clone()
+			sessionProxy := server.newSessionProxy("benchmark", dashboardHost)
+			sessionProxy.ReverseProxy.Transport = transport
+
+			proxyRequest(b, sessionProxy)
+			transport.CloseIdleConnections()
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type ReconcilerStats struct {
+	Runs                    atomic.Int64
+	Failures                atomic.Int64
+	OrphanedProjectsRemoved atomic.Int64
+	ExpiredSessionsRemoved  atomic.Int64
+}
+
+func (server *Server) runReconciler() {
+	if server.ReconcileInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(server.ReconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		server.reconcileProjects()
+		server.promoteQueuedSessions()
+		server.refillWarmPool()
+	}
+}
+
+func (server *Server) reconcileProjects() {
+	server.ReconcilerStats.Runs.Add(1)
+
+	projects, err := server.Runtime.ListProjects()
+	if err != nil {
+		server.ReconcilerStats.Failures.Add(1)
+		slog.Error("Reconciler: Failed to list projects", "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	knownProjects := map[string]bool{}
+	expiredSessionIds := []string{}
+	orphanedProjects := []string{}
+
+	server.SessionsMutex.RLock()
+	for _, session := range server.Sessions {
+		knownProjects[session.SanitizedId] = true
+
+		if session.Phase == SessionPhaseReady && !session.Pooled && !now.Before(session.ExpiresUtc) {
+			expiredSessionIds = append(expiredSessionIds, session.Id)
+		}
+	}
+	for _, project := range projects {
+		if !knownProjects[project] && !server.StoppingProjects[project] {
+			orphanedProjects = append(orphanedProjects, project)
+		}
+	}
+	server.SessionsMutex.RUnlock()
+
+	expiredRemoved := 0
+	for _, sessionId := range expiredSessionIds {
+		logger := server.sessionIdLogger(sessionId)
+		logger.Info("Reconciler: Session is past its expiry, deleting")
+		controllerMetrics.SessionsExpired.inc("ttl")
+
+		if err := server.deleteSession(sessionId); err != nil {
+			server.ReconcilerStats.Failures.Add(1)
+			logger.Error("Reconciler: Failed to delete expired session", "error", err)
+			continue
+		}
+
+		server.ReconcilerStats.ExpiredSessionsRemoved.Add(1)
+		expiredRemoved++
+	}
+
+	orphanedRemoved := 0
+	for _, project := range orphanedProjects {
+		slog.Warn("Reconciler: Project has no session, tearing down", "project", project)
+
+		if err := server.stopSession(&Session{Id: project, SanitizedId: project}); err != nil {
+			server.ReconcilerStats.Failures.Add(1)
+			slog.Error("Reconciler: Failed to tear down orphaned project", "project", project, "error", err)
+			continue
+		}
+
+		server.ReconcilerStats.OrphanedProjectsRemoved.Add(1)
+		orphanedRemoved++
+	}
+
+	if len(expiredSessionIds) > 0 || len(orphanedProjects) > 0 {
+		slog.Info("Reconciler: Removed expired sessions and orphaned projects",
+			"expired", expiredRemoved,
+			"orphaned", orphanedRemoved,
+			"total_expired", server.ReconcilerStats.ExpiredSessionsRemoved.Load(),
+			"total_orphaned", server.ReconcilerStats.OrphanedProjectsRemoved.Load(),
+			"total_failures", server.ReconcilerStats.Failures.Load())
+	}
+}
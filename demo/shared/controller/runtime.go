@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+type SessionRuntime interface {
+	Prepare() error
+	Provision(session *Session) error
+	ResolveHosts(session *Session) (SessionHosts, error)
+	Teardown(session *Session) error
+	StreamLogs(ctx context.Context, session *Session, service string, since time.Time, stdout io.Writer, stderr io.Writer) error
+	WatchContainerEvents(ctx context.Context, session *Session, handleEvent func(event ContainerEvent)) error
+	ListProjects() ([]string, error)
+}
+
+type SessionHosts struct {
+	DashboardHost string
+	ClientHost    string
+	VoidHost      string
+}
+
+var sessionServices = []string{"dashboard", "client", "void"}
+
+func newSessionRuntime(name string) (SessionRuntime, error) {
+	switch name {
+	case "compose":
+		return &ComposeRuntime{ProjectFile: "session.yml"}, nil
+	case "fake":
+		return &FakeRuntime{}, nil
+	default:
+		return nil, fmt.Errorf("unknown session runtime %q", name)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ComposeRuntime struct {
+	ProjectFile string
+}
+
+// Ends a log stream without a retry, the container was removed or stopped
+var errContainerGone = errors.New("container is gone")
+
+func (runtime *ComposeRuntime) Prepare() error {
+	if output, err := dockerCommand("network", "prune", "-f").Output(); err != nil {
+		controllerMetrics.DockerCommandFailures.inc("network prune")
+		return fmt.Errorf("failed to prune docker networks: %v: %s", err, string(output))
+	}
+
+	build := dockerCommand("compose", "--file", runtime.ProjectFile, "build")
+
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	build.Stdin = os.Stdin
+
+	err := build.Run()
+	if err != nil {
+		controllerMetrics.DockerCommandFailures.inc("compose build")
+		return fmt.Errorf("docker compose build failed: %v", err)
+	}
+	return nil
+}
+
+func (runtime *ComposeRuntime) Provision(session *Session) error {
+	startOutputBytes, startError := runtime.composeCommand(session, "up", "--build", "--detach").CombinedOutput()
+	if startError != nil {
+		controllerMetrics.DockerCommandFailures.inc("compose up")
+		return fmt.Errorf("failed to start containers with docker compose: %v: %s", startError, string(startOutputBytes))
+	}
+
+	return nil
+}
+
+func (runtime *ComposeRuntime) ResolveHosts(session *Session) (SessionHosts, error) {
+	containerIdBytes, listContainersError := runtime.composeCommand(session, "ps", "-q").Output()
+	if listContainersError != nil {
+		controllerMetrics.DockerCommandFailures.inc("compose ps")
+		return SessionHosts{}, fmt.Errorf("failed to list compose containers: %v", listContainersError)
+	}
+
+	containerIds := strings.Fields(string(containerIdBytes))
+	if len(containerIds) == 0 {
+		return SessionHosts{}, fmt.Errorf("no containers returned by docker compose ps -q")
+	}
+
+	inspectArguments := []string{
+		"inspect",
+		"--format",
+		"{{.Name}} {{index .Config.Labels \"com.docker.compose.service\"}}",
+	}
+	inspectArguments = append(inspectArguments, containerIds...)
+
+	inspectOutputBytes, inspectError := dockerCommand(inspectArguments...).CombinedOutput()
+	if inspectError != nil {
+		controllerMetrics.DockerCommandFailures.inc("inspect")
+		return SessionHosts{}, fmt.Errorf("failed to inspect compose containers: %v: %s", inspectError, string(inspectOutputBytes))
+	}
+
+	hosts := SessionHosts{}
+
+	inspectLines := strings.SplitSeq(strings.TrimSpace(string(inspectOutputBytes)), "\n")
+	for inspectLine := range inspectLines {
+		inspectFields := strings.Fields(inspectLine)
+		if len(inspectFields) < 2 {
+			continue
+		}
+
+		containerName := strings.TrimPrefix(strings.TrimSpace(inspectFields[0]), "/")
+		composeServiceName := strings.TrimSpace(inspectFields[1])
+
+		switch composeServiceName {
+		case "dashboard":
+			hosts.DashboardHost = containerName
+		case "client":
+			hosts.ClientHost = containerName
+		case "void":
+			hosts.VoidHost = containerName
+		}
+	}
+
+	if strings.TrimSpace(hosts.DashboardHost) == "" {
+		return SessionHosts{}, fmt.Errorf("dashboard container name not found")
+	}
+
+	if strings.TrimSpace(hosts.ClientHost) == "" {
+		return SessionHosts{}, fmt.Errorf("client container name not found")
+	}
+
+	if strings.TrimSpace(hosts.VoidHost) == "" {
+		return SessionHosts{}, fmt.Errorf("void container name not found")
+	}
+
+	return hosts, nil
+}
+
+func (runtime *ComposeRuntime) Teardown(session *Session) error {
+	stopOutput, stopErr := runtime.composeCommand(session, "down", "--remove-orphans", "--volumes").CombinedOutput()
+	if stopErr != nil {
+		controllerMetrics.DockerCommandFailures.inc("compose down")
+		return fmt.Errorf("docker compose down failed: %v: %s", stopErr, string(stopOutput))
+	}
+	return nil
+}
+
+func (runtime *ComposeRuntime) StreamLogs(ctx context.Context, session *Session, service string, since time.Time, stdout io.Writer, stderr io.Writer) error {
+	containerIdBytes, err := runtime.composeCommand(session, "ps", "-q", service).Output()
+	if err != nil {
+		controllerMetrics.DockerCommandFailures.inc("compose ps")
+		return fmt.Errorf("failed to find %s container: %v", service, err)
+	}
+
+	containerId := strings.TrimSpace(string(containerIdBytes))
+	if containerId == "" {
+		return fmt.Errorf("%s container not found", service)
+	}
+
+	logsArguments := []string{"logs", "--follow", "--tail=0"}
+	if !since.IsZero() {
+		logsArguments = []string{"logs", "--follow", "--since", since.Format(time.RFC3339Nano)}
+	}
+
+	logsCommand := dockerCommandContext(ctx, append(logsArguments, containerId)...)
+
+	var stderrBuffer bytes.Buffer
+	logsCommand.Stdout = stdout
+	logsCommand.Stderr = io.MultiWriter(stderr, &stderrBuffer)
+
+	err = logsCommand.Run()
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+
+	stderrText := stderrBuffer.String()
+	if strings.Contains(stderrText, "No such container") || strings.Contains(stderrText, "is not running") {
+		return errContainerGone
+	}
+
+	controllerMetrics.DockerCommandFailures.inc("logs")
+	return err
+}
+
+type dockerContainerEvent struct {
+	Action   string `json:"Action"`
+	TimeNano int64  `json:"timeNano"`
+	Actor    struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+func (runtime *ComposeRuntime) WatchContainerEvents(ctx context.Context, session *Session, handleEvent func(event ContainerEvent)) error {
+	eventsCommand := dockerCommandContext(ctx, "events",
+		"--filter", "type=container",
+		"--filter", "label=com.docker.compose.project="+session.SanitizedId,
+		"--filter", "event=start",
+		"--filter", "event=die",
+		"--format", "{{json .}}")
+
+	var stderrBuffer bytes.Buffer
+	eventsCommand.Stderr = &stderrBuffer
+
+	eventsOutput, err := eventsCommand.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open docker events output: %v", err)
+	}
+
+	if err := eventsCommand.Start(); err != nil {
+		controllerMetrics.DockerCommandFailures.inc("events")
+		return fmt.Errorf("failed to start docker events: %v", err)
+	}
+
+	// Unreadable events are skipped and reported when the stream ends, the runtime has no session logger
+	skippedEvents := 0
+	var skipError error
+	scanner := bufio.NewScanner(eventsOutput)
+	for scanner.Scan() {
+		var dockerEvent dockerContainerEvent
+		if err := json.Unmarshal(scanner.Bytes(), &dockerEvent); err != nil {
+			skippedEvents++
+			skipError = err
+			continue
+		}
+
+		service := dockerEvent.Actor.Attributes["com.docker.compose.service"]
+		if service == "" {
+			continue
+		}
+
+		exitCode, _ := strconv.Atoi(dockerEvent.Actor.Attributes["exitCode"])
+		handleEvent(ContainerEvent{
+			Service:  service,
+			Action:   dockerEvent.Action,
+			ExitCode: exitCode,
+			Time:     time.Unix(0, dockerEvent.TimeNano).UTC(),
+		})
+	}
+
+	err = eventsCommand.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == nil {
+		if skippedEvents > 0 {
+			return fmt.Errorf("skipped %d unreadable docker events: %w", skippedEvents, skipError)
+		}
+		return nil
+	}
+
+	controllerMetrics.DockerCommandFailures.inc("events")
+	return fmt.Errorf("docker events failed: %v: %s", err, strings.TrimSpace(stderrBuffer.String()))
+}
+
+func (runtime *ComposeRuntime) ListProjects() ([]string, error) {
+	listOutputBytes, listError := dockerCommand("ps", "--all", "--filter", "label=com.docker.compose.project", "--format", "{{.Label \"com.docker.compose.project\"}} {{.Label \"com.docker.compose.project.config_files\"}}").CombinedOutput()
+	if listError != nil {
+		controllerMetrics.DockerCommandFailures.inc("ps")
+		return nil, fmt.Errorf("failed to list compose containers: %v: %s", listError, string(listOutputBytes))
+	}
+
+	projectFileName := filepath.Base(runtime.ProjectFile)
+	seenProjects := map[string]bool{}
+	projects := []string{}
+
+	listLines := strings.SplitSeq(strings.TrimSpace(string(listOutputBytes)), "\n")
+	for listLine := range listLines {
+		listFields := strings.Fields(listLine)
+		if len(listFields) < 2 {
+			continue
+		}
+
+		projectName := listFields[0]
+		if seenProjects[projectName] {
+			continue
+		}
+
+		for configFile := range strings.SplitSeq(listFields[1], ",") {
+			if filepath.Base(configFile) == projectFileName {
+				seenProjects[projectName] = true
+				projects = append(projects, projectName)
+				break
+			}
+		}
+	}
+
+	return projects, nil
+}
+
+func (runtime *ComposeRuntime) composeCommand(session *Session, arguments ...string) *exec.Cmd {
+	composeArguments := []string{"compose", "--project-name", session.SanitizedId, "--file", runtime.ProjectFile}
+	composeArguments = append(composeArguments, arguments...)
+	return dockerCommand(composeArguments...)
+}
+
+func dockerCommand(arguments ...string) *exec.Cmd {
+	return dockerCommandContext(context.Background(), arguments...)
+}
+
+func dockerCommandContext(ctx context.Context, arguments ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, "docker", arguments...)
+	command.Env = os.Environ()
+	return command
+}
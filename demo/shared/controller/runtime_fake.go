@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FakeRuntime struct {
+	Stacks map[string]*fakeSessionStack
+	Mutex  sync.Mutex
+
+	// Makes the fake Minecraft client fail to launch so provisioning fails after the containers started
+	FailGameLaunch bool
+}
+
+type fakeSessionStack struct {
+	Dashboard *httptest.Server
+	Client    *httptest.Server
+	Void      *httptest.Server
+	Done      chan struct{}
+	Events    chan ContainerEvent
+	LogLines  map[string]chan string
+
+	GameStatus     clientGameStatus
+	FailGameLaunch bool
+	GameMutex      sync.Mutex
+}
+
+func (runtime *FakeRuntime) Prepare() error {
+	return nil
+}
+
+func (runtime *FakeRuntime) Provision(session *Session) error {
+	runtime.Mutex.Lock()
+	defer runtime.Mutex.Unlock()
+
+	if runtime.Stacks == nil {
+		runtime.Stacks = map[string]*fakeSessionStack{}
+	}
+
+	if _, ok := runtime.Stacks[session.SanitizedId]; ok {
+		return fmt.Errorf("fake stack for session %s already exists", session.Id)
+	}
+
+	stack := &fakeSessionStack{
+		Done:           make(chan struct{}),
+		Events:         make(chan ContainerEvent),
+		LogLines:       map[string]chan string{},
+		GameStatus:     clientGameStatus{State: "stopped"},
+		FailGameLaunch: runtime.FailGameLaunch,
+	}
+
+	for _, service := range sessionServices {
+		stack.LogLines[service] = make(chan string)
+	}
+
+	stack.Dashboard = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprintf(writer, "<!doctype html><title>Fake dashboard</title><p>Session %s at %s, view only %s</p>", session.Id, path.Join("/", request.Header.Get("X-Path-Prefix"), request.URL.Path), request.Header.Get("X-View-Only"))
+	}))
+	stack.Void = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.WriteString(writer, "fake void")
+	}))
+	stack.Client = httptest.NewServer(stack.clientHandler())
+
+	runtime.Stacks[session.SanitizedId] = stack
+	return nil
+}
+
+func (runtime *FakeRuntime) ResolveHosts(session *Session) (SessionHosts, error) {
+	stack, err := runtime.stack(session)
+	if err != nil {
+		return SessionHosts{}, err
+	}
+
+	return SessionHosts{
+		DashboardHost: stack.Dashboard.Listener.Addr().String(),
+		ClientHost:    stack.Client.Listener.Addr().String(),
+		VoidHost:      stack.Void.Listener.Addr().String(),
+	}, nil
+}
+
+func (runtime *FakeRuntime) Teardown(session *Session) error {
+	runtime.Mutex.Lock()
+	stack, ok := runtime.Stacks[session.SanitizedId]
+	if ok {
+		delete(runtime.Stacks, session.SanitizedId)
+	}
+	runtime.Mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	close(stack.Done)
+	stack.Dashboard.Close()
+	stack.Client.Close()
+	stack.Void.Close()
+	return nil
+}
+
+func (runtime *FakeRuntime) StreamLogs(ctx context.Context, session *Session, service string, since time.Time, stdout io.Writer, stderr io.Writer) error {
+	stack, err := runtime.stack(session)
+	if err != nil {
+		return err
+	}
+
+	if since.IsZero() {
+		_, _ = fmt.Fprintf(stdout, "fake %s service started\n", service)
+	}
+
+	for {
+		select {
+		case line := <-stack.LogLines[service]:
+			_, _ = fmt.Fprintln(stdout, line)
+		case <-stack.Done:
+			_, _ = fmt.Fprintf(stdout, "fake %s service stopped\n", service)
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (runtime *FakeRuntime) WatchContainerEvents(ctx context.Context, session *Session, handleEvent func(event ContainerEvent)) error {
+	stack, err := runtime.stack(session)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event := <-stack.Events:
+			handleEvent(event)
+		case <-stack.Done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Delivers a container event to the session as if docker reported it, returns once the watcher received it
+func (runtime *FakeRuntime) sendContainerEvent(session *Session, event ContainerEvent) error {
+	stack, err := runtime.stack(session)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case stack.Events <- event:
+		return nil
+	case <-stack.Done:
+		return fmt.Errorf("fake stack for session %s stopped", session.Id)
+	}
+}
+
+// Writes a line to the output of a fake service, returns once the log stream received it
+func (runtime *FakeRuntime) writeLogLine(session *Session, service string, line string) error {
+	stack, err := runtime.stack(session)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case stack.LogLines[service] <- line:
+		return nil
+	case <-stack.Done:
+		return fmt.Errorf("fake stack for session %s stopped", session.Id)
+	}
+}
+
+func (runtime *FakeRuntime) ListProjects() ([]string, error) {
+	runtime.Mutex.Lock()
+	defer runtime.Mutex.Unlock()
+
+	projects := make([]string, 0, len(runtime.Stacks))
+	for project := range runtime.Stacks {
+		projects = append(projects, project)
+	}
+
+	return projects, nil
+}
+
+func (runtime *FakeRuntime) stack(session *Session) (*fakeSessionStack, error) {
+	runtime.Mutex.Lock()
+	defer runtime.Mutex.Unlock()
+
+	stack, ok := runtime.Stacks[session.SanitizedId]
+	if !ok {
+		return nil, fmt.Errorf("fake stack for session %s not found", session.Id)
+	}
+
+	return stack, nil
+}
+
+func (stack *fakeSessionStack) clientHandler() http.Handler {
+	writeStatus := func(writer http.ResponseWriter, statusCode int) {
+		stack.GameMutex.Lock()
+		status := stack.GameStatus
+		stack.GameMutex.Unlock()
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(statusCode)
+		_ = json.NewEncoder(writer).Encode(status)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/health", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.WriteString(writer, "ok")
+	})
+	mux.HandleFunc("/api/game/status", func(writer http.ResponseWriter, request *http.Request) {
+		writeStatus(writer, http.StatusOK)
+	})
+	mux.HandleFunc("/api/game/start/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || strings.TrimPrefix(request.URL.Path, "/api/game/start/") == "" {
+			http.NotFound(writer, request)
+			return
+		}
+
+		stack.GameMutex.Lock()
+		stack.GameStatus.OperationId++
+		stack.GameStatus.State = "ready"
+		stack.GameStatus.OperationState = "succeeded"
+		stack.GameStatus.Error = ""
+		if stack.FailGameLaunch {
+			stack.GameStatus.State = "failed"
+			stack.GameStatus.OperationState = "failed"
+			stack.GameStatus.Error = "fake launch failure"
+		}
+		stack.GameMutex.Unlock()
+
+		writeStatus(writer, http.StatusAccepted)
+	})
+	mux.HandleFunc("/api/game/connect", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.NotFound(writer, request)
+			return
+		}
+
+		writeStatus(writer, http.StatusOK)
+	})
+	mux.HandleFunc("/api/game/stop", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.NotFound(writer, request)
+			return
+		}
+
+		stack.GameMutex.Lock()
+		stack.GameStatus.State = "stopped"
+		stack.GameMutex.Unlock()
+
+		writeStatus(writer, http.StatusOK)
+	})
+
+	return mux
+}
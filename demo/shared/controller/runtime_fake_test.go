@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFakeRuntimeSessionRuntimeContract(t *testing.T) {
+	fakeRuntime := &FakeRuntime{}
+	var runtime SessionRuntime = fakeRuntime
+
+	session := newSession("contract-session")
+	session.SanitizedId = "contract-session"
+
+	if err := runtime.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if projects, err := runtime.ListProjects(); err != nil || len(projects) != 0 {
+		t.Fatalf("projects before provisioning = %v, %v, expected none", projects, err)
+	}
+
+	if err := runtime.Provision(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.Provision(session); err == nil {
+		t.Fatal("provisioning the same session twice succeeded")
+	}
+	if projects, err := runtime.ListProjects(); err != nil || !slices.Equal(projects, []string{session.SanitizedId}) {
+		t.Fatalf("projects = %v, %v, expected %s", projects, err, session.SanitizedId)
+	}
+
+	hosts, err := runtime.ResolveHosts(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, host := range map[string]string{"dashboard": hosts.DashboardHost, "client": hosts.ClientHost, "void": hosts.VoidHost} {
+		response, err := http.Get("http://" + host + "/")
+		if err != nil {
+			t.Fatalf("%s host %q is not reachable: %v", name, host, err)
+		}
+		response.Body.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logReader, logWriter := io.Pipe()
+	logsDone := make(chan error, 1)
+	go func() {
+		logsDone <- runtime.StreamLogs(ctx, session, "void", time.Time{}, logWriter, io.Discard)
+		logWriter.Close()
+	}()
+
+	events := make(chan ContainerEvent, 1)
+	eventsDone := make(chan error, 1)
+	go func() {
+		eventsDone <- runtime.WatchContainerEvents(ctx, session, func(event ContainerEvent) {
+			events <- event
+		})
+	}()
+
+	logLines := bufio.NewScanner(logReader)
+	if !logLines.Scan() {
+		t.Fatalf("log stream ended before the first line: %v", logLines.Err())
+	}
+	if err := fakeRuntime.writeLogLine(session, "void", "proxy output"); err != nil {
+		t.Fatal(err)
+	}
+	if !logLines.Scan() || logLines.Text() != "proxy output" {
+		t.Fatalf("log line = %q, expected %q", logLines.Text(), "proxy output")
+	}
+
+	if err := fakeRuntime.sendContainerEvent(session, ContainerEvent{Service: "client", Action: "die", ExitCode: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Service != "client" || event.Action != "die" || event.ExitCode != 1 {
+		t.Fatalf("event = %+v, expected the client to die with code 1", event)
+	}
+
+	if err := runtime.Teardown(session); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, logReader)
+
+	for name, done := range map[string]chan error{"log stream": logsDone, "event watcher": eventsDone} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s returned %v after the teardown", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s kept running after the teardown", name)
+		}
+	}
+
+	if projects, err := runtime.ListProjects(); err != nil || len(projects) != 0 {
+		t.Fatalf("projects after the teardown = %v, %v, expected none", projects, err)
+	}
+	if _, err := runtime.ResolveHosts(session); err == nil {
+		t.Fatal("resolved hosts of a torn down session")
+	}
+	if err := runtime.StreamLogs(ctx, session, "void", time.Time{}, io.Discard, io.Discard); err == nil {
+		t.Fatal("streamed logs of a torn down session")
+	}
+	if err := runtime.Teardown(session); err != nil {
+		t.Fatalf("second teardown returned %v, expected it to be a no-op", err)
+	}
+
+	if err := runtime.Provision(session); err != nil {
+		t.Fatalf("provisioning after a teardown returned %v", err)
+	}
+	if err := runtime.Teardown(session); err != nil {
+		t.Fatal(err)
+	}
+}
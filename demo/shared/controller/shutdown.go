@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func (server *Server) shutdown(httpServer *http.Server) {
+	started := time.Now()
+	server.ShuttingDown.Store(true)
+
+	slog.Info("Shutting down, no new sessions are accepted")
+
+	// Hijacked WebSockets are invisible to http.Server.Shutdown and would keep streaming to the sessions
+	if closedTunnels := server.closeSessionProxies(); closedTunnels > 0 {
+		slog.Info("Closed open WebSockets", "count", closedTunnels)
+	}
+
+	drainContext, cancelDrain := context.WithTimeout(context.Background(), server.ShutdownDrainTimeout)
+	defer cancelDrain()
+
+	if err := httpServer.Shutdown(drainContext); err != nil {
+		slog.Warn("HTTP server did not shut down cleanly, closing remaining connections", "error", err, "timeout", server.ShutdownDrainTimeout)
+		_ = httpServer.Close()
+	}
+
+	// Teardown gets its own deadline so slow requests during the drain cannot leave stacks running
+	shutdownContext, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancel()
+
+	server.SessionsMutex.RLock()
+	sessionIds := make([]string, 0, len(server.Sessions))
+	for sessionId := range server.Sessions {
+		sessionIds = append(sessionIds, sessionId)
+	}
+	server.SessionsMutex.RUnlock()
+
+	if !server.ShutdownTeardown {
+		server.persistSessions()
+		slog.Info("Shutdown completed, sessions preserved for the next controller", "duration", time.Since(started).Truncate(time.Millisecond), "preserved", len(sessionIds))
+		return
+	}
+
+	var deletedCount atomic.Int64
+	var failedCount atomic.Int64
+	var waitGroup sync.WaitGroup
+	semaphore := make(chan struct{}, max(server.ShutdownParallelism, 1))
+
+	for _, sessionId := range sessionIds {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+			case <-shutdownContext.Done():
+				return
+			}
+			defer func() { <-semaphore }()
+
+			logger := server.sessionIdLogger(sessionId)
+			if err := server.deleteSession(sessionId); err != nil {
+				failedCount.Add(1)
+				logger.Error("Shutdown: Failed to delete session", "error", err)
+				return
+			}
+
+			deletedCount.Add(1)
+		}()
+	}
+
+	allDeleted := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(allDeleted)
+	}()
+
+	select {
+	case <-allDeleted:
+	case <-shutdownContext.Done():
+		slog.Warn("Shutdown: Deadline reached before all sessions were deleted", "timeout", server.ShutdownTimeout)
+	}
+
+	server.persistSessions()
+
+	remainingCount := int64(len(sessionIds)) - deletedCount.Load() - failedCount.Load()
+	slog.Info("Shutdown completed", "duration", time.Since(started).Truncate(time.Millisecond), "deleted", deletedCount.Load(), "failed", failedCount.Load(), "left_running", remainingCount)
+}
+
+func (server *Server) closeSessionProxies() int {
+	server.SessionsMutex.RLock()
+	defer server.SessionsMutex.RUnlock()
+
+	closedTunnels := 0
+	for _, session := range server.Sessions {
+		closedTunnels += session.Proxy.close()
+	}
+
+	return closedTunnels
+}
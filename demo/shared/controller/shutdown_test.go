@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdownTeardownOutlivesSlowRequests(t *testing.T) {
+	server := newTestServer(t)
+	server.ShutdownTeardown = true
+	server.ShutdownDrainTimeout = 100 * time.Millisecond
+	server.ShutdownTimeout = 5 * time.Second
+	server.ShutdownParallelism = 2
+
+	slowRequestStarted := make(chan struct{})
+	controllerHandler := server.handler()
+	httpServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/slow" {
+			close(slowRequestStarted)
+			<-request.Context().Done()
+			return
+		}
+
+		controllerHandler.ServeHTTP(writer, request)
+	}))
+	defer httpServer.Close()
+
+	sessionId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+
+	server.SessionsMutex.RLock()
+	session := server.Sessions[sessionId]
+	server.SessionsMutex.RUnlock()
+
+	clientConn, upstreamConn := net.Pipe()
+	defer clientConn.Close()
+	if !session.Proxy.registerTunnel(&sessionTunnel{Upstream: "vnc", OpenedUtc: time.Now().UTC(), ClientConn: clientConn, UpstreamConn: upstreamConn}) {
+		t.Fatal("failed to register the test tunnel")
+	}
+
+	go func() {
+		response, err := http.Get(httpServer.URL + "/slow")
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+	<-slowRequestStarted
+
+	started := time.Now()
+	server.shutdown(httpServer.Config)
+
+	if elapsed := time.Since(started); elapsed > server.ShutdownTimeout {
+		t.Fatalf("shutdown took %s", elapsed)
+	}
+	if projects, _ := server.Runtime.ListProjects(); len(projects) != 0 {
+		t.Fatalf("slow request used up the teardown deadline, stacks left running: %v", projects)
+	}
+	if _, err := clientConn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("WebSocket tunnel was left open")
+	}
+}
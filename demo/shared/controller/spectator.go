@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	webSocketOpcodeContinuation = 0x0
+	webSocketOpcodeText         = 0x1
+	webSocketOpcodeBinary       = 0x2
+	webSocketOpcodeClose        = 0x8
+
+	maxSpectatorFramePayload = 1 << 20
+
+	ttydHandshakeStart = '{'
+)
+
+type webSocketFrame struct {
+	Fin     bool
+	Rsv     byte
+	Opcode  byte
+	MaskKey [4]byte
+	Payload []byte
+}
+
+// Spectator tunnels parse what the browser sends and only forward messages that cannot change the session
+type webSocketInputFilter interface {
+	filterFrame(frame *webSocketFrame) (bool, error)
+}
+
+func newSpectatorInputFilter(upstream string) (webSocketInputFilter, error) {
+	switch upstream {
+	case "vnc":
+		return &rfbInputFilter{}, nil
+	case "void", "itzg":
+		return &ttydInputFilter{}, nil
+	default:
+		return nil, fmt.Errorf("spectators cannot open websockets to %s", upstream)
+	}
+}
+
+func readWebSocketFrame(reader io.Reader) (*webSocketFrame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	frame := &webSocketFrame{
+		Fin:    header[0]&0x80 != 0,
+		Rsv:    (header[0] >> 4) & 0x7,
+		Opcode: header[0] & 0xf,
+	}
+
+	if header[1]&0x80 == 0 {
+		return nil, errors.New("client websocket frame is not masked")
+	}
+
+	payloadLength := uint64(header[1] & 0x7f)
+	switch payloadLength {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return nil, err
+		}
+		payloadLength = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return nil, err
+		}
+		payloadLength = binary.BigEndian.Uint64(extended)
+	}
+
+	if payloadLength > maxSpectatorFramePayload {
+		return nil, fmt.Errorf("websocket frame of %d bytes is too large", payloadLength)
+	}
+
+	if _, err := io.ReadFull(reader, frame.MaskKey[:]); err != nil {
+		return nil, err
+	}
+
+	frame.Payload = make([]byte, payloadLength)
+	if _, err := io.ReadFull(reader, frame.Payload); err != nil {
+		return nil, err
+	}
+
+	for index := range frame.Payload {
+		frame.Payload[index] ^= frame.MaskKey[index%4]
+	}
+
+	return frame, nil
+}
+
+func writeWebSocketFrame(writer io.Writer, frame *webSocketFrame) error {
+	header := make([]byte, 0, 14)
+
+	firstByte := frame.Rsv<<4 | frame.Opcode
+	if frame.Fin {
+		firstByte |= 0x80
+	}
+	header = append(header, firstByte)
+
+	payloadLength := len(frame.Payload)
+	switch {
+	case payloadLength < 126:
+		header = append(header, 0x80|byte(payloadLength))
+	case payloadLength <= 0xffff:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(payloadLength))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(payloadLength))
+	}
+	header = append(header, frame.MaskKey[:]...)
+
+	maskedPayload := make([]byte, payloadLength)
+	for index, value := range frame.Payload {
+		maskedPayload[index] = value ^ frame.MaskKey[index%4]
+	}
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(maskedPayload)
+	return err
+}
+
+func copyFilteredWebSocket(writer io.Writer, reader io.Reader, filter webSocketInputFilter, onDropped func()) error {
+	for {
+		frame, err := readWebSocketFrame(reader)
+		if err != nil {
+			return err
+		}
+
+		// Extensions like permessage-deflate are stripped from spectator handshakes, so payloads are always readable
+		if frame.Rsv != 0 {
+			return errors.New("unexpected websocket extension bits")
+		}
+
+		if frame.Opcode < webSocketOpcodeClose {
+			forward, err := filter.filterFrame(frame)
+			if err != nil {
+				return err
+			}
+
+			if !forward {
+				onDropped()
+				continue
+			}
+		}
+
+		if err := writeWebSocketFrame(writer, frame); err != nil {
+			return err
+		}
+
+		if frame.Opcode == webSocketOpcodeClose {
+			return nil
+		}
+	}
+}
+
+// ttyd starts the terminal after a JSON handshake, every later message is input, resize or pause and resume flow control
+type ttydInputFilter struct {
+	HandshakeForwarded bool
+	DroppingMessage    bool
+}
+
+func (filter *ttydInputFilter) filterFrame(frame *webSocketFrame) (bool, error) {
+	if frame.Opcode != webSocketOpcodeContinuation {
+		isHandshake := !filter.HandshakeForwarded && len(frame.Payload) > 0 && frame.Payload[0] == ttydHandshakeStart
+		filter.HandshakeForwarded = true
+		filter.DroppingMessage = !isHandshake
+	}
+
+	return !filter.DroppingMessage, nil
+}
+
+type rfbClientState int
+
+const (
+	rfbClientVersion rfbClientState = iota
+	rfbClientSecurityType
+	rfbClientVncAuthResponse
+	rfbClientInit
+	rfbClientMessages
+)
+
+// Follows the client side of an RFB 3.7/3.8 stream and drops keyboard, pointer, clipboard and resize messages
+type rfbInputFilter struct {
+	State   rfbClientState
+	Pending []byte
+}
+
+func (filter *rfbInputFilter) filterFrame(frame *webSocketFrame) (bool, error) {
+	if frame.Opcode == webSocketOpcodeText {
+		return false, errors.New("text frames are not part of a binary RFB stream")
+	}
+
+	filter.Pending = append(filter.Pending, frame.Payload...)
+
+	output := []byte{}
+	for {
+		length, allowed, err := filter.nextItemLength()
+		if err != nil {
+			return false, err
+		}
+		if length < 0 {
+			return false, fmt.Errorf("invalid RFB item length %d", length)
+		}
+		if length == 0 || len(filter.Pending) < length {
+			break
+		}
+
+		if allowed {
+			output = append(output, filter.Pending[:length]...)
+		}
+		filter.Pending = filter.Pending[length:]
+	}
+
+	frame.Payload = output
+	return len(output) > 0 || !frame.Fin || frame.Opcode == webSocketOpcodeContinuation, nil
+}
+
+// Returns how many bytes the next complete client item needs, zero when more data is required to tell
+func (filter *rfbInputFilter) nextItemLength() (int, bool, error) {
+	pending := filter.Pending
+
+	switch filter.State {
+	case rfbClientVersion:
+		if len(pending) < 12 {
+			return 0, false, nil
+		}
+		if string(pending[:12]) != "RFB 003.008\n" && string(pending[:12]) != "RFB 003.007\n" {
+			return 0, false, fmt.Errorf("unsupported RFB version %q", pending[:12])
+		}
+		filter.State = rfbClientSecurityType
+		return 12, true, nil
+	case rfbClientSecurityType:
+		if len(pending) < 1 {
+			return 0, false, nil
+		}
+		switch pending[0] {
+		case 1:
+			filter.State = rfbClientInit
+		case 2:
+			filter.State = rfbClientVncAuthResponse
+		default:
+			return 0, false, fmt.Errorf("unsupported RFB security type %d", pending[0])
+		}
+		return 1, true, nil
+	case rfbClientVncAuthResponse:
+		if len(pending) < 16 {
+			return 0, false, nil
+		}
+		filter.State = rfbClientInit
+		return 16, true, nil
+	case rfbClientInit:
+		if len(pending) < 1 {
+			return 0, false, nil
+		}
+		// An exclusive ClientInit would make the server disconnect every other viewer, the owner included
+		pending[0] = 1
+		filter.State = rfbClientMessages
+		return 1, true, nil
+	}
+
+	if len(pending) < 1 {
+		return 0, false, nil
+	}
+
+	switch messageType := pending[0]; messageType {
+	case 0: // SetPixelFormat
+		return 20, true, nil
+	case 2: // SetEncodings
+		if len(pending) < 4 {
+			return 0, false, nil
+		}
+		return 4 + 4*int(binary.BigEndian.Uint16(pending[2:4])), true, nil
+	case 3: // FramebufferUpdateRequest
+		return 10, true, nil
+	case 4: // KeyEvent
+		return 8, false, nil
+	case 5: // PointerEvent
+		return 6, false, nil
+	case 6: // ClientCutText, negative lengths carry extended clipboard data
+		if len(pending) < 8 {
+			return 0, false, nil
+		}
+		textLength := int64(int32(binary.BigEndian.Uint32(pending[4:8])))
+		if textLength < 0 {
+			textLength = -textLength
+		}
+		if textLength > maxSpectatorFramePayload {
+			return 0, false, fmt.Errorf("RFB clipboard message of %d bytes is too large", textLength)
+		}
+		return 8 + int(textLength), false, nil
+	case 150: // EnableContinuousUpdates
+		return 10, true, nil
+	case 248: // ClientFence
+		if len(pending) < 9 {
+			return 0, false, nil
+		}
+		return 9 + int(pending[8]), true, nil
+	case 251: // SetDesktopSize
+		if len(pending) < 8 {
+			return 0, false, nil
+		}
+		return 8 + 16*int(pending[6]), false, nil
+	default:
+		return 0, false, fmt.Errorf("unsupported RFB client message type %d", messageType)
+	}
+}
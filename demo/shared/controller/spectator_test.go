@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var testMaskKey = [4]byte{0x12, 0x34, 0x56, 0x78}
+
+func encodeTestFrames(t *testing.T, frames ...*webSocketFrame) []byte {
+	t.Helper()
+
+	buffer := &bytes.Buffer{}
+	for _, frame := range frames {
+		frame.MaskKey = testMaskKey
+		if err := writeWebSocketFrame(buffer, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+func binaryFrame(payload ...byte) *webSocketFrame {
+	return &webSocketFrame{Fin: true, Opcode: webSocketOpcodeBinary, Payload: payload}
+}
+
+// Feeds the encoded frames one byte at a time so frame headers and payloads are split across reads
+func runSpectatorFilter(t *testing.T, filter webSocketInputFilter, frames ...*webSocketFrame) ([]*webSocketFrame, int, error) {
+	t.Helper()
+
+	output := &bytes.Buffer{}
+	dropped := 0
+	err := copyFilteredWebSocket(output, iotest.OneByteReader(bytes.NewReader(encodeTestFrames(t, frames...))), filter, func() {
+		dropped++
+	})
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	forwarded := []*webSocketFrame{}
+	for output.Len() > 0 {
+		frame, readErr := readWebSocketFrame(output)
+		if readErr != nil {
+			t.Fatalf("forwarded frames are malformed: %v", readErr)
+		}
+		forwarded = append(forwarded, frame)
+	}
+
+	return forwarded, dropped, err
+}
+
+func TestReadWebSocketFrame(t *testing.T) {
+	mediumPayload := bytes.Repeat([]byte{'m'}, 300)
+	largePayload := bytes.Repeat([]byte{'l'}, 70000)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		payload []byte
+		err     string
+	}{
+		{name: "short payload", data: encodeTestFrames(t, binaryFrame('h', 'i')), payload: []byte("hi")},
+		{name: "empty payload", data: encodeTestFrames(t, binaryFrame()), payload: []byte{}},
+		{name: "16 bit length", data: encodeTestFrames(t, binaryFrame(mediumPayload...)), payload: mediumPayload},
+		{name: "64 bit length", data: encodeTestFrames(t, binaryFrame(largePayload...)), payload: largePayload},
+		{name: "unmasked", data: []byte{0x82, 0x02, 'h', 'i'}, err: "not masked"},
+		{name: "oversized 64 bit length", data: []byte{0x82, 0xff, 0, 0, 0, 0, 0, 0x20, 0, 0}, err: "too large"},
+		{name: "length with top bit set", data: []byte{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0}, err: "too large"},
+		{name: "truncated header", data: []byte{0x82}, err: "EOF"},
+		{name: "truncated payload", data: []byte{0x82, 0x85, 1, 2, 3, 4, 'a'}, err: "EOF"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame, err := readWebSocketFrame(iotest.OneByteReader(bytes.NewReader(test.data)))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, expected %q", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(frame.Payload, test.payload) {
+				t.Fatalf("payload = %q, expected %q", frame.Payload, test.payload)
+			}
+		})
+	}
+}
+
+func TestTtydInputFilter(t *testing.T) {
+	ttydHandshake := `{"AuthToken":"","columns":80,"rows":24}`
+
+	tests := []struct {
+		name      string
+		frames    []*webSocketFrame
+		forwarded []string
+		dropped   int
+	}{
+		{
+			name:      "handshake kept and input dropped",
+			frames:    []*webSocketFrame{binaryFrame([]byte(ttydHandshake)...), binaryFrame([]byte("0ls\n")...)},
+			forwarded: []string{ttydHandshake},
+			dropped:   1,
+		},
+		{
+			name:      "resize dropped",
+			frames:    []*webSocketFrame{binaryFrame([]byte(ttydHandshake)...), binaryFrame([]byte(`1{"columns":300,"rows":100}`)...)},
+			forwarded: []string{ttydHandshake},
+			dropped:   1,
+		},
+		{
+			name:      "pause and resume dropped",
+			frames:    []*webSocketFrame{binaryFrame([]byte(ttydHandshake)...), binaryFrame('2'), binaryFrame('3')},
+			forwarded: []string{ttydHandshake},
+			dropped:   2,
+		},
+		{
+			name:      "second handshake dropped",
+			frames:    []*webSocketFrame{binaryFrame([]byte(ttydHandshake)...), binaryFrame([]byte(ttydHandshake)...)},
+			forwarded: []string{ttydHandshake},
+			dropped:   1,
+		},
+		{
+			name:      "command before the handshake dropped",
+			frames:    []*webSocketFrame{binaryFrame([]byte("0ls\n")...), binaryFrame([]byte(ttydHandshake)...)},
+			forwarded: []string{},
+			dropped:   2,
+		},
+		{
+			name:      "text frames filtered too",
+			frames:    []*webSocketFrame{{Fin: true, Opcode: webSocketOpcodeText, Payload: []byte(ttydHandshake)}, {Fin: true, Opcode: webSocketOpcodeText, Payload: []byte("0rm -rf /\n")}},
+			forwarded: []string{ttydHandshake},
+			dropped:   1,
+		},
+		{
+			name: "fragmented input dropped with its continuations",
+			frames: []*webSocketFrame{
+				binaryFrame([]byte(ttydHandshake)...),
+				{Opcode: webSocketOpcodeBinary, Payload: []byte("0a")},
+				{Opcode: webSocketOpcodeContinuation, Payload: []byte("b")},
+				{Fin: true, Opcode: webSocketOpcodeContinuation, Payload: []byte("c")},
+			},
+			forwarded: []string{ttydHandshake},
+			dropped:   3,
+		},
+		{
+			name: "fragmented handshake kept",
+			frames: []*webSocketFrame{
+				{Opcode: webSocketOpcodeBinary, Payload: []byte(`{"AuthToken":"",`)},
+				{Fin: true, Opcode: webSocketOpcodeContinuation, Payload: []byte(`"columns":80,"rows":24}`)},
+				binaryFrame([]byte(`1{"columns":300,"rows":100}`)...),
+			},
+			forwarded: []string{`{"AuthToken":"",`, `"columns":80,"rows":24}`},
+			dropped:   1,
+		},
+		{
+			name: "control frames pass inside a dropped message",
+			frames: []*webSocketFrame{
+				binaryFrame([]byte(ttydHandshake)...),
+				{Opcode: webSocketOpcodeBinary, Payload: []byte("0a")},
+				{Fin: true, Opcode: 0x9, Payload: []byte("ping")},
+				{Fin: true, Opcode: webSocketOpcodeContinuation, Payload: []byte("b")},
+			},
+			forwarded: []string{ttydHandshake, "ping"},
+			dropped:   2,
+		},
+		{
+			name:      "empty message dropped",
+			frames:    []*webSocketFrame{binaryFrame()},
+			forwarded: []string{},
+			dropped:   1,
+		},
+		{
+			name:      "close ends the stream",
+			frames:    []*webSocketFrame{{Fin: true, Opcode: webSocketOpcodeClose}, binaryFrame([]byte(ttydHandshake)...)},
+			forwarded: []string{""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwarded, dropped, err := runSpectatorFilter(t, &ttydInputFilter{}, test.frames...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payloads := []string{}
+			for _, frame := range forwarded {
+				payloads = append(payloads, string(frame.Payload))
+			}
+
+			if strings.Join(payloads, "|") != strings.Join(test.forwarded, "|") || len(payloads) != len(test.forwarded) {
+				t.Fatalf("forwarded %q, expected %q", payloads, test.forwarded)
+			}
+			if dropped != test.dropped {
+				t.Fatalf("dropped %d frames, expected %d", dropped, test.dropped)
+			}
+		})
+	}
+}
+
+func rfbClientCutText(length int32, text ...byte) []byte {
+	message := []byte{6, 0, 0, 0}
+	message = binary.BigEndian.AppendUint32(message, uint32(length))
+	return append(message, text...)
+}
+
+func TestRfbInputFilter(t *testing.T) {
+	version := []byte("RFB 003.008\n")
+	noAuthHandshake := append(append([]byte{}, version...), 1, 1)
+	vncAuthHandshake := append(append(append([]byte{}, version...), 2), bytes.Repeat([]byte{0xaa}, 16)...)
+	vncAuthHandshake = append(vncAuthHandshake, 1)
+
+	setPixelFormat := append([]byte{0, 0, 0, 0}, bytes.Repeat([]byte{1}, 16)...)
+	setEncodings := []byte{2, 0, 0, 2, 0, 0, 0, 7, 0xff, 0xff, 0xff, 0x21}
+	updateRequest := []byte{3, 1, 0, 0, 0, 0, 0x04, 0x00, 0x03, 0x00}
+	continuousUpdates := []byte{150, 1, 0, 0, 0, 0, 0x04, 0x00, 0x03, 0x00}
+	clientFence := []byte{248, 0, 0, 0, 0, 0, 0, 1, 3, 'a', 'b', 'c'}
+
+	keyEvent := []byte{4, 1, 0, 0, 0, 0, 0, 'a'}
+	pointerEvent := []byte{5, 1, 0, 10, 0, 20}
+	cutText := rfbClientCutText(3, 'a', 'b', 'c')
+	extendedCutText := rfbClientCutText(-4, 1, 2, 3, 4)
+	setDesktopSize := append([]byte{251, 0, 0x04, 0x00, 0x03, 0x00, 1, 0}, bytes.Repeat([]byte{0}, 16)...)
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name      string
+		stream    []byte
+		chunkSize int
+		expected  []byte
+		err       string
+	}{
+		{
+			name:     "viewer messages forwarded",
+			stream:   join(noAuthHandshake, setPixelFormat, setEncodings, updateRequest, continuousUpdates, clientFence),
+			expected: join(noAuthHandshake, setPixelFormat, setEncodings, updateRequest, continuousUpdates, clientFence),
+		},
+		{
+			name:     "input messages dropped",
+			stream:   join(noAuthHandshake, keyEvent, updateRequest, pointerEvent, cutText, extendedCutText, setDesktopSize, updateRequest),
+			expected: join(noAuthHandshake, updateRequest, updateRequest),
+		},
+		{
+			name:      "messages split byte by byte",
+			stream:    join(noAuthHandshake, keyEvent, setEncodings, pointerEvent, cutText, updateRequest),
+			chunkSize: 1,
+			expected:  join(noAuthHandshake, setEncodings, updateRequest),
+		},
+		{
+			name:      "messages split across odd frames",
+			stream:    join(vncAuthHandshake, setDesktopSize, clientFence, keyEvent, updateRequest),
+			chunkSize: 7,
+			expected:  join(vncAuthHandshake, clientFence, updateRequest),
+		},
+		{
+			name:     "exclusive client init made shared",
+			stream:   join(version, []byte{1, 0}, updateRequest),
+			expected: join(noAuthHandshake, updateRequest),
+		},
+		{name: "unsupported version", stream: []byte("RFB 003.003\n"), err: "unsupported RFB version"},
+		{name: "unsupported security type", stream: join(version, []byte{16}), err: "unsupported RFB security type"},
+		{name: "unknown message type", stream: join(noAuthHandshake, []byte{255, 0, 0, 0}), err: "unsupported RFB client message type"},
+		{name: "minimum int32 clipboard length", stream: join(noAuthHandshake, rfbClientCutText(-2147483648)), err: "too large"},
+		{name: "oversized clipboard length", stream: join(noAuthHandshake, rfbClientCutText(maxSpectatorFramePayload+1)), err: "too large"},
+		{name: "oversized negative clipboard length", stream: join(noAuthHandshake, rfbClientCutText(-maxSpectatorFramePayload-1)), err: "too large"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames := []*webSocketFrame{}
+			if test.chunkSize == 0 {
+				frames = append(frames, binaryFrame(test.stream...))
+			} else {
+				for chunk := range slicesChunk(test.stream, test.chunkSize) {
+					frames = append(frames, binaryFrame(chunk...))
+				}
+			}
+
+			forwarded, _, err := runSpectatorFilter(t, &rfbInputFilter{}, frames...)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream := []byte{}
+			for _, frame := range forwarded {
+				if len(frame.Payload) == 0 {
+					t.Fatal("forwarded an empty frame for a fully dropped message")
+				}
+				stream = append(stream, frame.Payload...)
+			}
+
+			if !bytes.Equal(stream, test.expected) {
+				t.Fatalf("forwarded %v, expected %v", stream, test.expected)
+			}
+		})
+	}
+}
+
+func TestRfbInputFilterRejectsTextFrames(t *testing.T) {
+	_, _, err := runSpectatorFilter(t, &rfbInputFilter{}, &webSocketFrame{Fin: true, Opcode: webSocketOpcodeText, Payload: []byte("RFB 003.008\n")})
+	if err == nil || !strings.Contains(err.Error(), "text frames") {
+		t.Fatalf("error = %v, expected text frames to be rejected", err)
+	}
+}
+
+func TestRfbInputFilterKeepsFragmentedMessageFraming(t *testing.T) {
+	handshake := append([]byte("RFB 003.008\n"), 1, 1)
+	forwarded, dropped, err := runSpectatorFilter(t, &rfbInputFilter{},
+		&webSocketFrame{Opcode: webSocketOpcodeBinary, Payload: handshake},
+		&webSocketFrame{Opcode: webSocketOpcodeContinuation, Payload: []byte{4, 1, 0, 0}},
+		&webSocketFrame{Fin: true, Opcode: webSocketOpcodeContinuation, Payload: []byte{0, 0, 0, 'a'}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Continuations are forwarded even when emptied so the upstream still sees a finished message
+	if len(forwarded) != 3 || dropped != 0 || !forwarded[2].Fin || len(forwarded[1].Payload) != 0 || len(forwarded[2].Payload) != 0 {
+		t.Fatalf("fragmented message framing changed: %d frames, %d dropped", len(forwarded), dropped)
+	}
+}
+
+func slicesChunk(data []byte, size int) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for start := 0; start < len(data); start += size {
+			if !yield(data[start:min(start+size, len(data))]) {
+				return
+			}
+		}
+	}
+}
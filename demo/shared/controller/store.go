@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type SessionStore struct {
+	Path  string
+	Mutex sync.Mutex
+}
+
+type SessionRecord struct {
+	Id            string       `json:"id"`
+	SanitizedId   string       `json:"sanitizedId"`
+	DashboardHost string       `json:"dashboardHost"`
+	ClientHost    string       `json:"clientHost"`
+	VoidHost      string       `json:"voidHost"`
+	CreatedUtc    time.Time    `json:"createdUtc"`
+	ExpiresUtc    time.Time    `json:"expiresUtc"`
+	Phase         SessionPhase `json:"phase"`
+	Pooled        bool         `json:"pooled"`
+}
+
+func (store *SessionStore) Load() ([]SessionRecord, error) {
+	store.Mutex.Lock()
+	defer store.Mutex.Unlock()
+
+	data, err := os.ReadFile(store.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session store %s: %w", store.Path, err)
+	}
+
+	var records []SessionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse session store %s: %w", store.Path, err)
+	}
+
+	return records, nil
+}
+
+func (store *SessionStore) save(records []SessionRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	directory := filepath.Dir(store.Path)
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(directory, filepath.Base(store.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, writeError := temporaryFile.Write(data)
+	closeError := temporaryFile.Close()
+	if writeError == nil {
+		writeError = closeError
+	}
+	if writeError != nil {
+		_ = os.Remove(temporaryFile.Name())
+		return writeError
+	}
+
+	return os.Rename(temporaryFile.Name(), store.Path)
+}
+
+func (server *Server) persistSessions() {
+	server.Store.Mutex.Lock()
+	defer server.Store.Mutex.Unlock()
+
+	server.SessionsMutex.RLock()
+	records := make([]SessionRecord, 0, len(server.Sessions))
+	for _, session := range server.Sessions {
+		if session.Phase == SessionPhaseQueued || session.Phase == SessionPhaseFailed {
+			continue
+		}
+
+		records = append(records, SessionRecord{
+			Id:            session.Id,
+			SanitizedId:   session.SanitizedId,
+			DashboardHost: session.DashboardHost,
+			ClientHost:    session.ClientHost,
+			VoidHost:      session.VoidHost,
+			CreatedUtc:    session.CreatedUtc,
+			ExpiresUtc:    session.ExpiresUtc,
+			Phase:         session.Phase,
+			Pooled:        session.Pooled,
+		})
+	}
+	server.SessionsMutex.RUnlock()
+
+	if err := server.Store.save(records); err != nil {
+		slog.Error("Failed to persist sessions", "path", server.Store.Path, "error", err)
+	}
+}
+
+func (server *Server) restoreSessions() error {
+	records, err := server.Store.Load()
+	if err != nil {
+		return err
+	}
+
+	projects, err := server.Runtime.ListProjects()
+	if err != nil {
+		return err
+	}
+
+	runningProjects := map[string]bool{}
+	for _, project := range projects {
+		runningProjects[project] = true
+	}
+
+	now := time.Now().UTC()
+	restoredCount := 0
+
+	for _, record := range records {
+		session := newSession(record.Id)
+		session.SanitizedId = record.SanitizedId
+		session.DashboardHost = record.DashboardHost
+		session.ClientHost = record.ClientHost
+		session.VoidHost = record.VoidHost
+		session.CreatedUtc = record.CreatedUtc
+		session.ExpiresUtc = record.ExpiresUtc
+		session.Phase = record.Phase
+		session.Pooled = record.Pooled
+		session.LastActivityUtc = now
+
+		logger := session.loggerLocked()
+		projectRunning := runningProjects[session.SanitizedId]
+		delete(runningProjects, session.SanitizedId)
+
+		if !projectRunning {
+			logger.Info("Project is gone, dropping stored session", "project", session.SanitizedId)
+			continue
+		}
+
+		if session.Phase != SessionPhaseReady {
+			logger.Info("Was still starting when the controller stopped, tearing down")
+			server.teardownProject(session)
+			continue
+		}
+
+		if !session.Pooled && !now.Before(session.ExpiresUtc) {
+			logger.Info("Expired while the controller was down, tearing down", "expires_utc", session.ExpiresUtc)
+			server.teardownProject(session)
+			continue
+		}
+
+		hosts, err := server.Runtime.ResolveHosts(session)
+		if err != nil {
+			logger.Warn("Failed to resolve hosts, tearing down", "error", err)
+			server.teardownProject(session)
+			continue
+		}
+
+		session.DashboardHost = hosts.DashboardHost
+		session.ClientHost = hosts.ClientHost
+		session.VoidHost = hosts.VoidHost
+
+		server.SessionsMutex.Lock()
+		server.buildSessionProxyLocked(session)
+		server.Sessions[session.Id] = session
+		server.SessionsMutex.Unlock()
+
+		if !session.Pooled {
+			server.armDeleteTimer(session)
+		}
+
+		go server.runHealthMonitor(session)
+
+		for _, service := range sessionServices {
+			server.streamContainerLogs(session, service)
+		}
+		go server.watchContainerEvents(session)
+
+		restoredCount++
+		if session.Pooled {
+			logger.Info("Restored into the warm pool")
+		} else {
+			logger.Info("Restored", "expires_in", time.Until(session.ExpiresUtc).Truncate(time.Second))
+		}
+	}
+
+	server.persistSessions()
+
+	slog.Info("Restored stored sessions", "restored", restoredCount, "stored", len(records))
+
+	server.reconcileProjects()
+	return nil
+}
+
+func (server *Server) teardownProject(session *Session) {
+	if err := server.stopSession(session); err != nil {
+		server.sessionLogger(session).Error("Failed to tear down project", "project", session.SanitizedId, "error", err)
+	}
+}
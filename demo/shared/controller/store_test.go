@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestoreSessionsReconcilesProjects(t *testing.T) {
+	previousServer := newTestServer(t)
+	httpServer := httptest.NewServer(previousServer.handler())
+	defer httpServer.Close()
+
+	keptId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+	goneId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+	expiredId, _ := startTestSession(t, newTestClient(), httpServer.URL)
+
+	previousServer.persistSessions()
+	previousServer.Cancel()
+
+	records, err := previousServer.Store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("stored %d sessions, expected 3", len(records))
+	}
+
+	runtime := previousServer.Runtime.(*FakeRuntime)
+	projectOf := map[string]string{}
+	for index, record := range records {
+		projectOf[record.Id] = record.SanitizedId
+		if record.Id == expiredId {
+			records[index].ExpiresUtc = time.Now().UTC().Add(-time.Minute)
+		}
+	}
+	if err := previousServer.Store.save(records); err != nil {
+		t.Fatal(err)
+	}
+
+	// The controller was down while a stack disappeared and another one was left behind without a session
+	if err := runtime.Teardown(&Session{Id: goneId, SanitizedId: projectOf[goneId]}); err != nil {
+		t.Fatal(err)
+	}
+	orphan := &Session{Id: "orphan", SanitizedId: "orphan"}
+	if err := runtime.Provision(orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t)
+	server.Runtime = runtime
+	server.Store = previousServer.Store
+
+	if err := server.restoreSessions(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.SessionsMutex.RLock()
+	sessionIds := []string{}
+	for sessionId := range server.Sessions {
+		sessionIds = append(sessionIds, sessionId)
+	}
+	kept := server.Sessions[keptId]
+	server.SessionsMutex.RUnlock()
+
+	if !slices.Equal(sessionIds, []string{keptId}) {
+		t.Fatalf("restored sessions %v, expected only %s", sessionIds, keptId)
+	}
+
+	hosts, err := runtime.ResolveHosts(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.DashboardHost != hosts.DashboardHost || kept.Proxy == nil {
+		t.Fatalf("restored session points at %s, expected %s", kept.DashboardHost, hosts.DashboardHost)
+	}
+
+	projects, err := runtime.ListProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(projects, []string{projectOf[keptId]}) {
+		t.Fatalf("projects left running %v, expected only %s", projects, projectOf[keptId])
+	}
+
+	restoredRecords, err := server.Store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restoredRecords) != 1 || restoredRecords[0].Id != keptId {
+		t.Fatalf("store holds %+v after the restore, expected only %s", restoredRecords, keptId)
+	}
+}
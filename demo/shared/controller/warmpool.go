@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const warmPoolRetryDelay = 30 * time.Second
+
+func (server *Server) countPooledSessionsLocked() (readyCount int, startingCount int) {
+	for _, session := range server.Sessions {
+		if !session.Pooled {
+			continue
+		}
+
+		if session.Phase == SessionPhaseReady {
+			readyCount++
+		} else {
+			startingCount++
+		}
+	}
+
+	return readyCount, startingCount
+}
+
+// The pooled session keeps its background workers and takes over the identity of the visitor session.
+func (server *Server) adoptPooledSessionLocked(session *Session) *Session {
+	var pooledSession *Session
+	for _, candidate := range server.Sessions {
+		if candidate.Pooled && candidate.Phase == SessionPhaseReady && candidate.Healthy && (pooledSession == nil || candidate.CreatedUtc.Before(pooledSession.CreatedUtc)) {
+			pooledSession = candidate
+		}
+	}
+
+	if pooledSession == nil {
+		return nil
+	}
+
+	delete(server.Sessions, pooledSession.Id)
+	session.Cancel()
+
+	pooledSession.Id = session.Id
+	pooledSession.CreatedUtc = session.CreatedUtc
+	pooledSession.ExpiresUtc = time.Now().UTC().Add(server.SessionTtl)
+	pooledSession.ClientAddress = session.ClientAddress
+	pooledSession.LastPolledUtc = session.LastPolledUtc
+	pooledSession.LastActivityUtc = session.CreatedUtc
+	pooledSession.Watcher = session.Watcher
+	pooledSession.Pooled = false
+	server.buildSessionProxyLocked(pooledSession)
+	pooledSession.Watcher.notify()
+
+	return pooledSession
+}
+
+func (server *Server) refillWarmPool() {
+	if server.WarmPoolSize <= 0 || server.ShuttingDown.Load() {
+		return
+	}
+
+	createdSessions := []*Session{}
+
+	server.SessionsMutex.Lock()
+	readyCount, startingCount := server.countPooledSessionsLocked()
+	for pooledCount := readyCount + startingCount; pooledCount < server.WarmPoolSize; pooledCount++ {
+		if len(server.Queue) > 0 || (server.MaxSessions > 0 && server.countActiveSessionsLocked() >= server.MaxSessions) {
+			break
+		}
+
+		sessionId, err := createSessionId()
+		if err != nil {
+			slog.Error("Failed to generate warm pool session id", "error", err)
+			break
+		}
+
+		session := newSession(sessionId)
+		session.Pooled = true
+		controllerMetrics.SessionsCreated.inc("true")
+		session.setPhaseLocked(SessionPhaseComposing)
+
+		server.Sessions[session.Id] = session
+		createdSessions = append(createdSessions, session)
+	}
+	server.SessionsMutex.Unlock()
+
+	if len(createdSessions) == 0 {
+		return
+	}
+
+	slog.Info("Refilling warm pool", "created", len(createdSessions), "ready", readyCount, "starting", startingCount)
+	server.persistSessions()
+
+	for _, session := range createdSessions {
+		go server.provisionSession(session)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailedWarmPoolSessionStopsBackgroundWorkers(t *testing.T) {
+	server := newTestServer(t)
+	server.Runtime = &FakeRuntime{FailGameLaunch: true}
+
+	session := newSession("pooled-session")
+	session.Pooled = true
+	session.setPhaseLocked(SessionPhaseComposing)
+	server.Sessions[session.Id] = session
+
+	_, subscriber, unsubscribe := session.Logs.subscribe("", 0)
+	defer unsubscribe()
+
+	server.provisionSession(session)
+
+	server.SessionsMutex.RLock()
+	_, exists := server.Sessions[session.Id]
+	server.SessionsMutex.RUnlock()
+	if exists {
+		t.Fatal("failed warm pool session is still in the session map")
+	}
+
+	if session.Context.Err() == nil {
+		t.Fatal("failed warm pool session context was not cancelled")
+	}
+
+	timeout := time.After(5 * time.Second)
+	for subscriberOpen := true; subscriberOpen; {
+		select {
+		case _, subscriberOpen = <-subscriber:
+		case <-timeout:
+			t.Fatal("log subscriber was not closed")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.ActiveLogStreams.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d log streams are still retrying against the removed stack", server.ActiveLogStreams.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
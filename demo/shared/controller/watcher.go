@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	sessionWatcherInterval  = time.Second
+	sessionWatcherHeartbeat = 10 * time.Second
+)
+
+type SessionWatcher struct {
+	Subscribers map[chan sessionStatusResponse]bool
+	Changed     chan struct{}
+	Running     bool
+	Mutex       sync.Mutex
+}
+
+func (watcher *SessionWatcher) notify() {
+	if watcher == nil {
+		return
+	}
+
+	select {
+	case watcher.Changed <- struct{}{}:
+	default:
+	}
+}
+
+func (server *Server) subscribeSessionStatus(sessionId string) (chan sessionStatusResponse, func(), bool) {
+	server.SessionsMutex.Lock()
+	session, ok := server.Sessions[sessionId]
+	if !ok || session.Pooled {
+		server.SessionsMutex.Unlock()
+		return nil, nil, false
+	}
+
+	if session.Watcher == nil {
+		session.Watcher = &SessionWatcher{
+			Subscribers: map[chan sessionStatusResponse]bool{},
+			Changed:     make(chan struct{}, 1),
+		}
+	}
+	watcher := session.Watcher
+	server.SessionsMutex.Unlock()
+
+	updates := make(chan sessionStatusResponse, 1)
+
+	watcher.Mutex.Lock()
+	watcher.Subscribers[updates] = true
+	startWatcher := !watcher.Running
+	watcher.Running = true
+	watcher.Mutex.Unlock()
+
+	if startWatcher {
+		go server.runSessionWatcher(sessionId, watcher)
+	} else {
+		watcher.notify()
+	}
+
+	unsubscribe := func() {
+		watcher.Mutex.Lock()
+		delete(watcher.Subscribers, updates)
+		watcher.Mutex.Unlock()
+	}
+
+	return updates, unsubscribe, true
+}
+
+func (server *Server) runSessionWatcher(sessionId string, watcher *SessionWatcher) {
+	ticker := time.NewTicker(sessionWatcherInterval)
+	defer ticker.Stop()
+
+	var lastStatus sessionStatusResponse
+	var lastSentUtc time.Time
+	forceSend := true
+
+	for {
+		status := server.sessionStatus(sessionId)
+
+		comparableStatus := status
+		comparableStatus.SecondsLeft = lastStatus.SecondsLeft
+		changed := forceSend || !reflect.DeepEqual(comparableStatus, lastStatus) || time.Since(lastSentUtc) >= sessionWatcherHeartbeat
+
+		watcher.Mutex.Lock()
+		if changed {
+			for subscriber := range watcher.Subscribers {
+				select {
+				case <-subscriber:
+				default:
+				}
+				subscriber <- status
+			}
+
+			lastStatus = status
+			lastSentUtc = time.Now()
+		}
+
+		if !status.Exists {
+			for subscriber := range watcher.Subscribers {
+				close(subscriber)
+				delete(watcher.Subscribers, subscriber)
+			}
+		}
+
+		if len(watcher.Subscribers) == 0 {
+			watcher.Running = false
+			watcher.Mutex.Unlock()
+			return
+		}
+		watcher.Mutex.Unlock()
+
+		select {
+		case <-watcher.Changed:
+			forceSend = true
+		case <-ticker.C:
+			forceSend = false
+		}
+	}
+}
+
+func (server *Server) handleStatusEvents(writer http.ResponseWriter, request *http.Request, sessionId string) {
+	responseController := http.NewResponseController(writer)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+
+	writeEvent := func(status sessionStatusResponse) bool {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return false
+		}
+
+		if _, err := fmt.Fprintf(writer, "event: status\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+
+		return responseController.Flush() == nil
+	}
+
+	updates, unsubscribe, ok := server.subscribeSessionStatus(sessionId)
+	if !ok {
+		writeEvent(sessionStatusResponse{SessionId: sessionId})
+		return
+	}
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-server.Context.Done():
+			return
+		case status, open := <-updates:
+			if !open {
+				return
+			}
+
+			if !writeEvent(status) {
+				server.sessionIdLogger(sessionId).Debug("Status stream closed")
+				return
+			}
+		}
+	}
+}